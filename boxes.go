@@ -0,0 +1,180 @@
+package docgen
+
+import (
+	"math"
+
+	"github.com/Maldris/commonmarkDocgen/rules"
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// barWidth is the default width of the bar down the side of a blockquote, in points
+const barWidth = 1 * mm
+
+// box is a box drawn around content, or a bar down the side of a blockquote, shaded ahead of its content and outlined once it is written,
+// a page or column at a time
+type box struct {
+	style     Style
+	bar       bool    // a bar down the left side rather than a box
+	left      float64 // left edge of the box
+	right     float64 // right edge of the box
+	pad       float64 // space between the edges of the box and its content
+	top       float64 // top of the box on the current page or column
+	remaining float64 // estimated height of the box still to be placed, to shade it ahead of its content
+	continued bool    // the box started on an earlier page or column, so isn't closed at the top
+	broken    bool    // the box reached the end of the page or column, and is waiting to continue on the next
+}
+
+// boxStyle gives the style of the box opened by tok, a callout or a blockquote, and if a box is drawn for it
+func (d *Document) boxStyle(tok markdown.Token) (Style, bool) {
+	switch tk := tok.(type) {
+	case *rules.BoxOpen:
+		style, ok := d.theme.Callouts[tk.Kind]
+		if !ok {
+			return d.theme.Callout, true
+		}
+		return style.merged(d.theme.Callout), true
+	case *markdown.BlockquoteOpen:
+		style := d.theme.Blockquote
+		return style, style.BorderColor != "" || style.Background != ""
+	}
+	return Style{}, false
+}
+
+// openCallout starts a callout box, writing its content within its padding, in its style
+func (d *Document) openCallout(tk *rules.BoxOpen) {
+	style, _ := d.boxStyle(tk)
+	if d.fpdf.GetX() > d.leftMargin+1e-6 {
+		d.fpdf.Write(d.lineHeight, "\n")
+	}
+	pad := d.sizes.CellMargin
+	d.openBox(style, false, pad)
+	d.leftMargin += pad
+	d.fpdf.SetLeftMargin(d.leftMargin)
+	_, _, rm, _ := d.fpdf.GetMargins()
+	d.fpdf.SetRightMargin(rm + pad)
+	d.fpdf.SetXY(d.leftMargin, d.fpdf.GetY()+pad)
+	d.pushStyle(Style{FontFamily: style.FontFamily, FontStyle: style.FontStyle, FontSize: style.FontSize, Color: style.Color})
+}
+
+// closeCallout ends the callout box opened last, leaving the space after a paragraph below it
+func (d *Document) closeCallout() {
+	if len(d.boxes) == 0 || d.boxes[len(d.boxes)-1].bar {
+		// an \endbox without a box to end
+		return
+	}
+	d.popStyle()
+	b := d.boxes[len(d.boxes)-1]
+	d.leftMargin -= b.pad
+	d.fpdf.SetLeftMargin(d.leftMargin)
+	_, _, rm, _ := d.fpdf.GetMargins()
+	d.fpdf.SetRightMargin(rm - b.pad)
+	if d.fpdf.GetX() > d.leftMargin+1e-6 {
+		d.fpdf.Write(d.lineHeight, "\n")
+	}
+	d.fpdf.SetY(d.closeBox())
+	d.space(d.trailingSpace())
+}
+
+// openBox starts a box from the left margin to the right margin at the current position, padded by pad,
+// shading as much of the height measured for it by renderTokens as fits on the page
+func (d *Document) openBox(style Style, bar bool, pad float64) {
+	wd, _ := d.fpdf.GetPageSize()
+	_, _, rm, _ := d.fpdf.GetMargins()
+	b := &box{style: style, bar: bar, left: d.leftMargin, right: wd - rm, pad: pad}
+	// the measured height includes the space left after the last paragraph of the content, which the box doesn't
+	b.remaining = math.Max(0, d.boxHeight-d.trailingSpace())
+	d.boxHeight = 0
+	d.boxes = append(d.boxes, b)
+	d.startBox(b)
+}
+
+// closeBox ends the box opened last, outlining the last of it below the content written in it, and gives the bottom of the box
+func (d *Document) closeBox() float64 {
+	l := len(d.boxes)
+	if l == 0 {
+		return d.fpdf.GetY()
+	}
+	b := d.boxes[l-1]
+	d.boxes = d.boxes[:l-1]
+	bottom := math.Max(b.top, d.fpdf.GetY()-d.trailingSpace()) + b.pad
+	d.outlineBox(b, bottom, true)
+	return bottom
+}
+
+// trailingSpace gives the space left after the last paragraph of a box, up to which the box ends
+func (d *Document) trailingSpace() float64 {
+	return spacing(d.theme.Paragraph.SpaceAfter, d.lineHeight)
+}
+
+// startBox starts the part of a box on the current page or column at the current position, shading it ahead of its content
+func (d *Document) startBox(b *box) {
+	b.top = d.fpdf.GetY()
+	b.broken = false
+	fill, ok := d.color(b.style.Background)
+	if !ok || d.measuring || b.remaining <= 0 {
+		return
+	}
+	r, g, bl := d.fpdf.GetFillColor()
+	d.fpdf.SetFillColor(fill.r, fill.g, fill.b)
+	d.fpdf.Rect(b.left, b.top, b.right-b.left, math.Min(b.remaining, d.pageBottom()-b.top), "F")
+	d.fpdf.SetFillColor(r, g, bl)
+}
+
+// outlineBox draws the border of the part of a box on the current page or column down to bottom,
+// closing it at the top if it starts on this page or column, and at the bottom if it ends on it
+func (d *Document) outlineBox(b *box, bottom float64, closed bool) {
+	c, ok := d.color(b.style.BorderColor)
+	if !ok || d.measuring {
+		return
+	}
+	width := d.fpdf.GetLineWidth()
+	r, g, bl := d.fpdf.GetDrawColor()
+	d.fpdf.SetDrawColor(c.r, c.g, c.b)
+	if b.style.LineWidth > 0 {
+		d.fpdf.SetLineWidth(b.style.LineWidth)
+	}
+	if b.bar {
+		w := b.style.LineWidth
+		if w == 0 {
+			w = barWidth / d.fpdf.GetConversionRatio()
+		}
+		d.fpdf.SetLineWidth(w)
+		d.fpdf.Line(b.left+w/2, b.top, b.left+w/2, bottom)
+	} else {
+		d.fpdf.Line(b.left, b.top, b.left, bottom)
+		d.fpdf.Line(b.right, b.top, b.right, bottom)
+		if !b.continued {
+			d.fpdf.Line(b.left, b.top, b.right, b.top)
+		}
+		if closed {
+			d.fpdf.Line(b.left, bottom, b.right, bottom)
+		}
+	}
+	d.fpdf.SetLineWidth(width)
+	d.fpdf.SetDrawColor(r, g, bl)
+}
+
+// breakBoxes outlines the boxes open at the end of a page or column down to its bottom, to continue them on the next
+func (d *Document) breakBoxes() {
+	for _, b := range d.boxes {
+		if b.broken {
+			continue
+		}
+		d.outlineBox(b, d.pageBottom(), false)
+		b.remaining = math.Max(0, b.remaining-(d.fpdf.GetY()-b.top))
+		b.continued = true
+		b.broken = true
+	}
+}
+
+// resumeBoxes continues the boxes broken at the end of the last page or column from the current position, leaving the padding of the boxes at their top
+func (d *Document) resumeBoxes() {
+	for _, b := range d.boxes {
+		if !b.broken {
+			continue
+		}
+		b.remaining += b.pad
+		d.startBox(b)
+		d.fpdf.SetY(d.fpdf.GetY() + b.pad)
+	}
+}
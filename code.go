@@ -0,0 +1,162 @@
+package docgen
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Maldris/commonmarkDocgen/highlight"
+)
+
+// tabWidth is the number of columns between the tab stops of code
+const tabWidth = 4
+
+// codeRow is a row of a code block as written, a line of code or the part of one that wraps onto the next row
+type codeRow struct {
+	number string // line number written beside the row, empty for the rows a line wraps onto
+	tokens []highlight.Token
+}
+
+// fenceInfo reads the info string of a fenced code block, giving the language of the code and if its lines are numbered,
+// the language first, followed by options such as "numbered"
+func fenceInfo(info string) (lang string, numbered bool) {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return "", false
+	}
+	for _, option := range fields[1:] {
+		if strings.EqualFold(option, "numbered") {
+			numbered = true
+		}
+	}
+	return strings.ToLower(fields[0]), numbered
+}
+
+// codeBlock writes a block of code in lang a row at a time, in the code style on the code background, coloured by the syntax of the language,
+// wrapping lines too long for the block, and moving to the next page or column between rows, keeping the first and last rows with their neighbours
+func (d *Document) codeBlock(content, lang string, numbered bool) {
+	d.pushStyle(d.theme.Code)
+	margin := d.fpdf.GetCellMargin()
+	d.fpdf.SetCellMargin(0)
+
+	wd, _ := d.fpdf.GetPageSize()
+	_, _, rm, _ := d.fpdf.GetMargins()
+	width := wd - rm - d.leftMargin - 2*d.sizes.NominalIndent
+	lines := codeLines(highlight.Tokenize(lang, strings.TrimSuffix(content, "\n")))
+	gutter := 0.0
+	if numbered {
+		d.pushStyle(d.theme.Syntax["linenumber"])
+		gutter = d.fpdf.GetStringWidth(strconv.Itoa(len(lines))) + 2*margin
+		d.popStyle()
+	}
+	var rows []codeRow
+	for i, line := range lines {
+		number := ""
+		if numbered {
+			number = strconv.Itoa(i + 1)
+		}
+		for _, tokens := range d.wrapCode(line, width-gutter-2*margin) {
+			rows = append(rows, codeRow{number, tokens})
+			number = ""
+		}
+	}
+
+	for i, row := range rows {
+		need := 1
+		if i == 0 || len(rows)-i == minLines {
+			need = int(math.Min(minLines, float64(len(rows)-i)))
+		}
+		if !d.pageTemplate && d.fpdf.GetY()+float64(need)*d.lineHeight > d.pageBottom() && float64(need)*d.lineHeight <= d.freshSpace() {
+			d.pageBreak()
+		}
+		d.codeRow(row, width, gutter, margin)
+	}
+
+	d.fpdf.SetCellMargin(margin)
+	d.popStyle()
+	d.fpdf.SetX(d.leftMargin)
+	d.fpdf.Write(d.lineHeight, "\n")
+}
+
+// codeRow writes a row of a code block at the current position, shading the width of the block behind it, with its line number in the gutter
+func (d *Document) codeRow(row codeRow, width, gutter, margin float64) {
+	left := d.leftMargin + d.sizes.NominalIndent
+	y := d.fpdf.GetY()
+	if fill, ok := d.color(d.theme.Code.Background); ok {
+		r, g, b := d.fpdf.GetFillColor()
+		d.fpdf.SetFillColor(fill.r, fill.g, fill.b)
+		d.fpdf.Rect(left, y, width, d.lineHeight, "F")
+		d.fpdf.SetFillColor(r, g, b)
+	}
+	if row.number != "" {
+		d.pushStyle(d.theme.Syntax["linenumber"])
+		d.fpdf.SetXY(left, y)
+		d.fpdf.CellFormat(gutter-margin, d.lineHeight, row.number, "", 0, "R", false, 0, "")
+		d.popStyle()
+	}
+	d.fpdf.SetXY(left+gutter+margin, y)
+	for _, tok := range row.tokens {
+		d.pushStyle(d.theme.Syntax[string(tok.Kind)])
+		d.fpdf.CellFormat(d.fpdf.GetStringWidth(tok.Text), d.lineHeight, tok.Text, "", 0, "L", false, 0, "")
+		d.popStyle()
+	}
+	d.fpdf.SetXY(d.leftMargin, y+d.lineHeight)
+}
+
+// codeLines splits the tokens of a block of code into its lines, expanding tabs to the next tab stop
+func codeLines(tokens []highlight.Token) [][]highlight.Token {
+	lines := [][]highlight.Token{nil}
+	column := 0
+	for _, tok := range tokens {
+		for i, part := range strings.Split(tok.Text, "\n") {
+			if i > 0 {
+				lines = append(lines, nil)
+				column = 0
+			}
+			var b strings.Builder
+			for _, r := range part {
+				if r == '\t' {
+					spaces := tabWidth - column%tabWidth
+					b.WriteString(strings.Repeat(" ", spaces))
+					column += spaces
+					continue
+				}
+				b.WriteRune(r)
+				column++
+			}
+			if b.Len() > 0 {
+				l := len(lines) - 1
+				lines[l] = append(lines[l], highlight.Token{Kind: tok.Kind, Text: b.String()})
+			}
+		}
+	}
+	return lines
+}
+
+// wrapCode splits a line of code into rows no wider than width, breaking between characters, as code has no safe place to break
+func (d *Document) wrapCode(line []highlight.Token, width float64) [][]highlight.Token {
+	rows := [][]highlight.Token{nil}
+	used := 0.0
+	for _, tok := range line {
+		d.pushStyle(d.theme.Syntax[string(tok.Kind)])
+		start := 0
+		for i, r := range tok.Text {
+			w := d.fpdf.GetStringWidth(string(r))
+			if used+w > width && used > 0 {
+				l := len(rows) - 1
+				if i > start {
+					rows[l] = append(rows[l], highlight.Token{Kind: tok.Kind, Text: tok.Text[start:i]})
+				}
+				rows = append(rows, nil)
+				start, used = i, 0
+			}
+			used += w
+		}
+		if start < len(tok.Text) {
+			l := len(rows) - 1
+			rows[l] = append(rows[l], highlight.Token{Kind: tok.Kind, Text: tok.Text[start:]})
+		}
+		d.popStyle()
+	}
+	return rows
+}
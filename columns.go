@@ -0,0 +1,135 @@
+package docgen
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Maldris/commonmarkDocgen/rules"
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// columnRegion gives the tokens covered by a columns directive, up to the next columns directive
+func columnRegion(tokens []markdown.Token) []markdown.Token {
+	for i, tok := range tokens {
+		if _, ok := tok.(*rules.Columns); ok {
+			return tokens[:i]
+		}
+	}
+	return tokens
+}
+
+// columnsStart ends any current multi-column region and starts a new one, measuring the content of the region to balance the columns
+func (d *Document) columnsStart(tk *rules.Columns, region []markdown.Token) {
+	d.columnsEnd()
+	if tk.Count < 2 {
+		return
+	}
+
+	gutter := d.sizes.ColumnGutter
+	if tk.Gutter != (rules.Length{}) {
+		gutter = d.length(tk.Gutter, 0)
+	}
+	wd, _ := d.fpdf.GetPageSize()
+	lm, _, rm, bm := d.fpdf.GetMargins()
+	width := (wd - (lm + rm) - gutter*float64(tk.Count-1)) / float64(tk.Count)
+	if width <= 0 {
+		fmt.Printf("[docgen] No space for %v columns with a gutter of %v\r\n", tk.Count, gutter)
+		return
+	}
+
+	c := &d.columns
+	c.count = tk.Count
+	c.index = 0
+	c.width = width
+	c.gutter = gutter
+	c.left = lm
+	c.right = rm
+	c.bottom = bm
+	c.remaining = d.measure(region, width, 0)
+	d.fpdf.SetRightMargin(wd - (lm + width))
+	d.fpdf.SetX(d.leftMargin)
+	d.columnsPage()
+}
+
+// columnsEnd returns to a single column, continuing below the longest column
+func (d *Document) columnsEnd() {
+	c := &d.columns
+	if c.count < 2 {
+		return
+	}
+	y := math.Max(c.maxY, d.fpdf.GetY())
+	d.shiftColumns(-float64(c.index) * (c.width + c.gutter))
+	d.fpdf.SetRightMargin(c.right)
+	d.fpdf.SetAutoPageBreak(true, c.bottom)
+	d.fpdf.SetXY(d.leftMargin, y)
+	c.count = 0
+	c.index = 0
+}
+
+// columnsPage sets up the columns from the current position on a page, with the height of the columns chosen to share the remaining content between them
+func (d *Document) columnsPage() {
+	c := &d.columns
+	_, pageh := d.fpdf.GetPageSize()
+	c.top = d.fpdf.GetY()
+	c.maxY = c.top
+	avail := pageh - c.bottom - c.top
+	height := avail
+	if share := c.remaining / float64(c.count); share < avail {
+		// whole lines, so the break falls between lines rather than leaving the columns uneven
+		height = math.Min(avail, math.Ceil(share/d.lineHeight)*d.lineHeight)
+	}
+	c.remaining = math.Max(0, c.remaining-height*float64(c.count))
+	// a small allowance so rounding doesn't push the last line of a column over the break
+	d.fpdf.SetAutoPageBreak(true, pageh-(c.top+height+d.lineHeight/100))
+}
+
+// nextColumn moves the current position to the top of the next column, keeping its position relative to the column
+func (d *Document) nextColumn() {
+	c := &d.columns
+	c.maxY = math.Max(c.maxY, d.fpdf.GetY())
+	d.breakBoxes()
+	x := d.fpdf.GetX() + c.width + c.gutter
+	d.shiftColumns(c.width + c.gutter)
+	c.index++
+	if c.index == c.count-1 {
+		// the last column runs to the bottom of the page, so content the balancing underestimated isn't lost
+		d.fpdf.SetAutoPageBreak(true, c.bottom)
+	}
+	d.fpdf.SetXY(x, c.top)
+	d.resumeBoxes()
+	d.fpdf.SetX(x)
+}
+
+// shiftColumns moves the margins and indents across by delta, to move between columns
+func (d *Document) shiftColumns(delta float64) {
+	d.leftMargin += delta
+	for i := range d.indents {
+		d.indents[i] += delta
+	}
+	for _, b := range d.boxes {
+		b.left += delta
+		b.right += delta
+	}
+	lm, _, rm, _ := d.fpdf.GetMargins()
+	d.fpdf.SetLeftMargin(lm + delta)
+	d.fpdf.SetRightMargin(rm - delta)
+}
+
+// columnLeft gives the left edge of the current column
+func (d *Document) columnLeft() float64 {
+	return d.columns.left + float64(d.columns.index)*(d.columns.width+d.columns.gutter)
+}
+
+// acceptPageBreak is called by gofpdf when content reaches the page break, in a multi-column region it moves to the next column or page itself
+// any early break set to move lines of a paragraph onto the next page is cleared, so it doesn't carry over to the next page
+func (d *Document) acceptPageBreak() bool {
+	d.restoreBreak()
+	if d.columns.count < 2 {
+		auto, _ := d.fpdf.GetAutoPageBreak()
+		return auto
+	}
+	x := d.fpdf.GetX() - d.columnLeft()
+	d.pageBreak()
+	d.fpdf.SetX(d.columnLeft() + x)
+	return false
+}
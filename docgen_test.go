@@ -1,9 +1,16 @@
 package docgen
 
 import (
+	"fmt"
 	"log"
+	"math"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/Maldris/commonmarkDocgen/highlight"
+	"github.com/Maldris/commonmarkDocgen/rules"
+	"gitlab.com/golang-commonmark/markdown"
 )
 
 // TestMain is the root testing method
@@ -19,6 +26,927 @@ func TestGen(t *testing.T) {
 	}
 }
 
+// TestTableCellMerge tests resolving merge markers into cell spans
+func TestTableCellMerge(t *testing.T) {
+	doc := NewDocument("merge", "", nil)
+	doc.table.rows = [][]cell{
+		{{text: "a"}, {text: "<"}, {text: "b"}},
+		{{text: "^"}, {text: "^"}, {text: "c"}},
+		{{text: "d"}, {text: "^"}, {text: "<"}},
+	}
+	doc.mergeTableCells()
+
+	expected := [][][2]int{
+		{{2, 2}, {0, 0}, {1, 1}},
+		{{0, 0}, {0, 0}, {1, 1}},
+		// the lone ^ can't extend the 2x2 cell above rectangularly, so it stays as text that the < then joins
+		{{1, 1}, {2, 1}, {0, 0}},
+	}
+	for r, row := range expected {
+		for c, span := range row {
+			got := doc.table.rows[r][c]
+			if got.colSpan != span[0] || got.rowSpan != span[1] {
+				t.Errorf("cell %v,%v: expected span %v, got %v,%v", r, c, span, got.colSpan, got.rowSpan)
+			}
+		}
+	}
+
+	// escaped markers are written as text
+	for _, tok := range doc.parser.Parse([]byte("| a | b | c |\n|---|---|---|\n| \\< | \\^ | < |\n")) {
+		if _, ok := tok.(*markdown.TableClose); !ok {
+			doc.render(tok)
+		}
+	}
+	doc.mergeTableCells()
+	row := doc.table.rows[1]
+	if row[0].text != "<" || row[0].colSpan != 1 || row[1].text != "^" || row[1].colSpan != 2 || row[2].colSpan != 0 {
+		t.Errorf("expected escaped markers to be written as text, got %+v", row)
+	}
+
+	// values in tables from the table function are never merge markers
+	doc = NewDocument("merge", `{{table .ops (column "a") (column "b")}}`, nil)
+	err := doc.Execute(map[string]interface{}{"ops": []map[string]string{{"a": "x", "b": "<"}, {"a": "^", "b": "y"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range doc.table.rows {
+		for _, c := range row {
+			if c.colSpan != 1 || c.rowSpan != 1 {
+				t.Errorf("expected no merged cells, got %+v", doc.table.rows)
+			}
+		}
+	}
+}
+
+// TestTablePageTemplates tests a table running over several pages keeps its rows when the page header and footer have tables of their own
+func TestTablePageTemplates(t *testing.T) {
+	doc := NewDocument("tables", "| n |\n|---|\n"+strings.Repeat("| row |\n", 80), nil)
+	doc.SetPageHeader("| header | table |\n|---|---|\n| a | b |\n")
+	doc.SetPageFooter("| footer |\n|---|\n| c |\n")
+	if err := doc.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if doc.fpdf.PageCount() < 2 || len(doc.table.rows) != 81 {
+		t.Errorf("expected the table to run onto another page with its rows, got %v pages and %v rows", doc.fpdf.PageCount(), len(doc.table.rows))
+	}
+}
+
+// TestTableColumnWidths tests absolute, percentage and ratio table sizing
+func TestTableColumnWidths(t *testing.T) {
+	doc := NewDocument("widths", "", nil)
+	doc.table.rows = [][]cell{
+		{{text: "a", colSpan: 1, rowSpan: 1}, {text: "b", colSpan: 1, rowSpan: 1}, {text: "c", colSpan: 1, rowSpan: 1}},
+	}
+	tests := []struct {
+		thead    string
+		expected []float64
+	}{
+		{`\thead s120mm c:30mm:1:2`, []float64{30, 30, 60}},
+		{`\thead s60% c:1:1:1`, []float64{38, 38, 38}},
+		{`\thead sfull c:25%:1in:1`, []float64{47.5, 25.4, 117.1}},
+	}
+	for _, test := range tests {
+		tokens := doc.parser.Parse([]byte(test.thead + "\n"))
+		if len(tokens) != 1 {
+			t.Fatalf("%v: expected a single table header token, got %v", test.thead, tokens)
+		}
+		doc.render(tokens[0])
+		cols, _ := doc.calcTableColumnWidths()
+		if len(cols) != len(test.expected) {
+			t.Fatalf("%v: expected %v columns, got %v", test.thead, len(test.expected), cols)
+		}
+		for i := range cols {
+			if math.Abs(cols[i]-test.expected[i]) > 0.001 {
+				t.Errorf("%v: expected column widths %v, got %v", test.thead, test.expected, cols)
+				break
+			}
+		}
+	}
+}
+
+// TestTableRowHeights tests row heights include the vertical cell padding
+func TestTableRowHeights(t *testing.T) {
+	doc := NewDocument("heights", "", nil)
+	doc.table.rows = [][]cell{{{text: "a\nb"}}}
+	doc.mergeTableCells()
+	// without padding each line is given the cell margin
+	if heights := doc.calcTableRowHeights([]float64{50}); math.Abs(heights[0]-2*(doc.lineHeight+doc.sizes.CellMargin)) > 0.001 {
+		t.Errorf("expected the default row height to be 2 lines with their cell margin, got %v", heights[0])
+	}
+
+	tokens := doc.parser.Parse([]byte("\\thead p3mm:2mm vm\n"))
+	doc.render(tokens[0])
+	doc.table.rows = [][]cell{
+		{{text: "a"}, {text: "b"}},
+		{{text: "c\nd"}, {text: "^"}},
+	}
+	doc.mergeTableCells()
+	heights := doc.calcTableRowHeights([]float64{50, 50})
+	expected := []float64{doc.lineHeight + 4, 2*doc.lineHeight + 4}
+	for i := range expected {
+		if math.Abs(heights[i]-expected[i]) > 0.001 {
+			t.Errorf("expected row heights %v, got %v", expected, heights)
+			break
+		}
+	}
+}
+
+// TestTableCaptionNumbering tests captions are numbered in order and placed relative to their table
+func TestTableCaptionNumbering(t *testing.T) {
+	doc := NewDocument("captions", "", nil)
+	tokens := doc.parser.Parse([]byte(`\tcaption{above} first
+
+| a |
+| --- |
+| b |
+
+\tcaption below
+
+see table \tref{above}
+
+\tcaption{stray} stray
+
+not a table
+
+\tcaption last
+
+| c |
+| --- |
+| d |
+
+after the table
+
+\tcaption orphan
+`))
+	doc.numberTables(tokens)
+
+	var captions []*rules.TableCaption
+	for _, tok := range tokens {
+		if caption, ok := tok.(*rules.TableCaption); ok {
+			captions = append(captions, caption)
+		}
+	}
+	if len(captions) != 5 {
+		t.Fatalf("expected 5 captions, got %v", len(captions))
+	}
+	if captions[0].Number != 1 || captions[0].Below || captions[0].Label != "above" || captions[0].Text != "first" {
+		t.Errorf("unexpected first caption %+v", captions[0])
+	}
+	if captions[1].Number != 2 || !captions[1].Below || captions[1].Text != "below" {
+		t.Errorf("unexpected second caption %+v", captions[1])
+	}
+	// captions without a table directly after them are left unnumbered, rather than taking the number of a later table
+	for _, i := range []int{2, 4} {
+		if captions[i].Number != 0 {
+			t.Errorf("expected the caption without a table to be left unnumbered, got %+v", captions[i])
+		}
+	}
+	if _, ok := doc.table.labels["stray"]; ok || captions[3].Number != 3 || doc.table.count != 3 {
+		t.Errorf("expected the last table to be table 3, got %+v", captions[3])
+	}
+	if doc.table.labels["above"] != 1 {
+		t.Errorf("expected label to reference table 1, got %v", doc.table.labels["above"])
+	}
+}
+
+// TestPdfConfigValidate tests page sizes and margins are validated rather than replaced with defaults
+func TestPdfConfigValidate(t *testing.T) {
+	tests := []struct {
+		conf  PdfConfig
+		valid bool
+	}{
+		{PdfConfig{Portrait: true, Metric: true, Paper: "A4"}, true},
+		{PdfConfig{Metric: true, Paper: "tabloid"}, true},
+		{PdfConfig{Metric: true}, true},
+		{PdfConfig{Metric: true, Paper: "A0"}, false},
+		{PdfConfig{Metric: true, Width: 80, Height: 200, Margins: &Margins{Top: 5, Left: 4, Right: 4, Bottom: 5}}, true},
+		{PdfConfig{Metric: true, Width: 80}, false},
+		{PdfConfig{Metric: true, Paper: "A4", Width: 80, Height: 200}, false},
+		{PdfConfig{Metric: true, Width: -80, Height: 200}, false},
+		{PdfConfig{Metric: true, Width: 80, Height: 200, Margins: &Margins{Left: 40, Right: 40}}, false},
+		{PdfConfig{Metric: true, Paper: "A5", Margins: &Margins{Top: -1}}, false},
+		{PdfConfig{Paper: "Letter", Margins: &Margins{Top: 1, Left: 1.5, Right: 1, Bottom: 1}}, true},
+	}
+	for _, test := range tests {
+		err := test.conf.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid %v, got error %v", test.conf, test.valid, err)
+		}
+		doc := NewDocument("config", "test", &test.conf)
+		if err = doc.Execute(nil); (err == nil) != test.valid {
+			t.Errorf("%+v: expected execute to succeed %v, got error %v", test.conf, test.valid, err)
+		}
+	}
+}
+
+// TestCustomPage tests a custom page size and margins are used for the page and its content
+func TestCustomPage(t *testing.T) {
+	doc := NewDocument("receipt", `# Receipt
+
+\thead sfull
+
+| item | price |
+| --- | --- |
+| coffee | $4.50 |`, &PdfConfig{
+		Metric:  true,
+		Width:   80,
+		Height:  200,
+		Margins: &Margins{Top: 5, Left: 4, Right: 6, Bottom: 5},
+	})
+	doc.SetPageFooter("thank you")
+	err := doc.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, ht := doc.fpdf.GetPageSize()
+	if wd != 80 || ht != 200 {
+		t.Errorf("expected an 80x200 page, got %vx%v", wd, ht)
+	}
+	lm, tm, rm, _ := doc.fpdf.GetMargins()
+	if lm != 4 || tm != 5 || rm != 6 {
+		t.Errorf("expected margins 4, 5, 6, got %v, %v, %v", lm, tm, rm)
+	}
+	cols, _ := doc.calcTableColumnWidths()
+	if total := spanSum(cols, 0, len(cols)); math.Abs(total-70) > 0.001 {
+		t.Errorf("expected full width table to fill the 70mm between the margins, got %v", total)
+	}
+}
+
+// TestDocumentUnits tests documents lay out the same regardless of the unit of measurement
+func TestDocumentUnits(t *testing.T) {
+	template := `# Heading
+
+a paragraph of text, long enough to wrap onto a second line at the default page width and font size
+
+> a block quote
+
+- a list
+- of items
+
+| a | b |
+| --- | --- |
+| table | cells |
+
+    code block
+`
+	confs := []PdfConfig{
+		{Portrait: true, Metric: true, Paper: "A4"},
+		{Portrait: true, Metric: false, Paper: "A4"},
+		{Portrait: true, Unit: "cm", Paper: "A4"},
+		{Portrait: true, Unit: "pt", Paper: "A4"},
+	}
+	var expectedY float64
+	var expectedCols []float64
+	for i, conf := range confs {
+		conf := conf
+		doc := NewDocument("units", template, &conf)
+		err := doc.Execute(nil)
+		if err != nil {
+			t.Fatalf("%v: %v", conf.unit(), err)
+		}
+		if doc.fpdf.PageNo() != 1 {
+			t.Errorf("%v: expected the document to fit on a single page, got %v pages", conf.unit(), doc.fpdf.PageNo())
+		}
+		if pt := doc.fpdf.UnitToPointConvert(doc.lineHeight); math.Abs(pt-lineHeight) > 0.001 {
+			t.Errorf("%v: expected a line height of %vpt, got %vpt", conf.unit(), lineHeight, pt)
+		}
+		if pt := doc.fpdf.UnitToPointConvert(doc.sizes.NominalIndent); math.Abs(pt-nominalIndent) > 0.001 {
+			t.Errorf("%v: expected a nominal indent of %vpt, got %vpt", conf.unit(), nominalIndent, pt)
+		}
+		y := doc.fpdf.UnitToPointConvert(doc.fpdf.GetY())
+		cols, _ := doc.calcTableColumnWidths()
+		for c := range cols {
+			cols[c] = doc.fpdf.UnitToPointConvert(cols[c])
+		}
+		if i == 0 {
+			expectedY = y
+			expectedCols = cols
+		} else if math.Abs(y-expectedY) > 0.01 {
+			t.Errorf("%v: expected the content to end %vpt down the page, got %vpt", conf.unit(), expectedY, y)
+		}
+		for c := range cols {
+			if math.Abs(cols[c]-expectedCols[c]) > 0.01 {
+				t.Errorf("%v: expected table columns %vpt wide, got %vpt", conf.unit(), expectedCols, cols)
+				break
+			}
+		}
+	}
+	if err := (&PdfConfig{Unit: "furlong"}).Validate(); err == nil {
+		t.Error("expected an unknown unit to be invalid")
+	}
+}
+
+// TestPageFormatChange tests page breaks changing the size and orientation of the following pages
+func TestPageFormatChange(t *testing.T) {
+	template := "portrait A4\n\n\\page landscape\n\n" + strings.Repeat("landscape A4\n\n", 20) + "\\page A3\n\nlandscape A3\n\n\\page portrait A4\n\nportrait A4\n"
+	doc := NewDocument("pages", template, nil)
+	doc.SetPageFooter("page {{._page}}")
+	err := doc.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][2]float64{{210, 297}, {297, 210}, {297, 210}, {420, 297}, {210, 297}}
+	if doc.fpdf.PageCount() != len(expected) {
+		t.Fatalf("expected %v pages, got %v", len(expected), doc.fpdf.PageCount())
+	}
+	for i, size := range expected {
+		wd, ht, _ := doc.fpdf.PageSize(i + 1)
+		if math.Abs(wd-size[0]) > 0.5 || math.Abs(ht-size[1]) > 0.5 {
+			t.Errorf("page %v: expected %vx%v, got %vx%v", i+1, size[0], size[1], wd, ht)
+		}
+	}
+}
+
+// TestColumns tests content is balanced across columns, and flows onto the next page once the columns are full
+func TestColumns(t *testing.T) {
+	single := NewDocument("single", strings.Repeat("a short paragraph\n\n", 20), nil)
+	err := single.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewDocument("columns", "\\columns 2\n\n"+strings.Repeat("a short paragraph\n\n", 20)+"\\columns 1\n", nil)
+	err = doc.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, top, _, _ := doc.fpdf.GetMargins()
+	height := single.fpdf.GetY() - top
+	if got := doc.fpdf.GetY() - top; math.Abs(got-height/2) > 2*doc.lineHeight {
+		t.Errorf("expected balanced columns about %v high, got %v", height/2, got)
+	}
+	if lm, _, rm, _ := doc.fpdf.GetMargins(); math.Abs(lm-single.margins.Left) > 1e-6 || math.Abs(rm-single.margins.Right) > 1e-6 || math.Abs(doc.leftMargin-lm) > 1e-6 {
+		t.Errorf("expected the margins restored after the columns, got %v and %v", lm, rm)
+	}
+
+	doc = NewDocument("columns", "\\columns 3 1cm\n\n"+strings.Repeat("a short paragraph\n\n", 150), nil)
+	err = doc.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.fpdf.PageCount() != 2 {
+		t.Errorf("expected the columns to flow onto 2 pages, got %v", doc.fpdf.PageCount())
+	}
+
+	// a columns directive without a count is left as text
+	doc = NewDocument("columns", "", nil)
+	for _, src := range []string{"\\columns \n\ntext\n", "\\columns\n\ntext\n", "\\columns"} {
+		for _, tok := range doc.parser.Parse([]byte(src)) {
+			if _, ok := tok.(*rules.Columns); ok {
+				t.Errorf("%q: expected no columns without a count", src)
+			}
+		}
+	}
+
+	// any whitespace can follow the directive
+	for _, src := range []string{"\\columns\t2\n", "\\columns 2"} {
+		count := 0
+		for _, tok := range doc.parser.Parse([]byte(src)) {
+			if col, ok := tok.(*rules.Columns); ok {
+				count = col.Count
+			}
+		}
+		if count != 2 {
+			t.Errorf("%q: expected 2 columns, got %v", src, count)
+		}
+	}
+}
+
+// TestFitLine tests breaking lines of items at spaces and hyphens, and that justified text wraps the same as left aligned text
+func TestFitLine(t *testing.T) {
+	word := func(wd float64) lineItem { return lineItem{width: wd} }
+	space := lineItem{width: 1, space: true}
+	items := []lineItem{word(4), space, word(4), space, word(4), {brk: true}, word(2)}
+	cases := []struct {
+		start     int
+		avail     float64
+		atMargin  bool
+		end, next int
+		wrapped   bool
+	}{
+		{0, 20, true, 5, 6, false},
+		{0, 10, true, 3, 4, true},
+		{0, 3, true, 0, 0, true},
+		{0, 3, false, 0, 0, true},
+		{6, 10, true, 7, 7, false},
+	}
+	for i, c := range cases {
+		end, next, wrapped := fitLine(items, c.start, c.avail, c.atMargin)
+		if end != c.end || next != c.next || wrapped != c.wrapped {
+			t.Errorf("case %v: expected %v %v %v, got %v %v %v", i, c.end, c.next, c.wrapped, end, next, wrapped)
+		}
+	}
+
+	// hyphens only count at the end of a line, which is broken at the last hyphen that fits
+	hyphen := lineItem{width: 1, hyphen: true}
+	items = []lineItem{word(4), space, word(3), hyphen, word(3), hyphen, word(3)}
+	for i, c := range []struct {
+		avail     float64
+		end, next int
+	}{{9, 4, 4}, {13, 6, 6}, {14, 7, 7}, {8, 1, 2}} {
+		end, next, _ := fitLine(items, 0, c.avail, true)
+		if end != c.end || next != c.next {
+			t.Errorf("hyphen case %v: expected %v %v, got %v %v", i, c.end, c.next, end, next)
+		}
+	}
+
+	// justifying only spaces the words out, so the text wraps to the same lines as left aligned text
+	text := strings.Repeat("a **paragraph** of [text](http://example.com) that wraps ", 20) + "\nafter a break\n"
+	left := NewDocument("left", text, nil)
+	justified := NewDocument("justified", text, &PdfConfig{Portrait: true, Metric: true, Justify: true})
+	for _, doc := range []*Document{left, justified} {
+		err := doc.Execute(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if math.Abs(left.fpdf.GetY()-justified.fpdf.GetY()) > 1e-6 {
+		t.Errorf("expected justified text to end at %v, got %v", left.fpdf.GetY(), justified.fpdf.GetY())
+	}
+}
+
+// TestAlignedLines tests aligned text is laid out a line at a time, so styled text and links stay on the same line rather than each being aligned separately
+func TestAlignedLines(t *testing.T) {
+	line := "a **bold** [link](http://example.com) and \\\\*anchor*\\\\ text"
+	left := NewDocument("left", line+"\n", nil)
+	err := left.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, marker := range []string{"::", ":::"} {
+		doc := NewDocument("aligned", marker+line+marker+"\n", nil)
+		err := doc.Execute(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(left.fpdf.GetY()-doc.fpdf.GetY()) > 1e-6 {
+			t.Errorf("%v: expected the text on one line ending at %v, got %v", marker, left.fpdf.GetY(), doc.fpdf.GetY())
+		}
+	}
+}
+
+// TestPagination tests headings are kept with their content, paragraphs avoid widowed and orphaned lines, and kept blocks move to the next page together
+func TestPagination(t *testing.T) {
+	// render writes markdown with space for lines lines left on the first page, returning the page and lines down it the content ends at
+	render := func(lines float64, markdown string) (int, float64) {
+		doc := NewDocument("pagination", "", nil)
+		doc.params = map[string]interface{}{}
+		doc.fpdf.SetY(doc.pageBottom() - (lines+0.5)*doc.lineHeight)
+		doc.renderTokens(doc.parser.Parse([]byte(markdown)))
+		return doc.fpdf.PageNo(), (doc.fpdf.GetY() - doc.margins.Top) / doc.lineHeight
+	}
+	cases := []struct {
+		name     string
+		lines    float64
+		markdown string
+		page     int
+		y        float64
+	}{
+		{"heading kept with its content", 3, "# Heading\n\ntext\n", 2, 4},
+		{"paragraphs fitting the page", 4, "text\n\ntext\n", 1, 0},
+		{"orphaned first line", 2, "one\ntwo\nthree\n", 2, 4},
+		{"widowed last line", 4, "one\ntwo\nthree\nfour\nfive\n", 2, 3},
+		{"keep together", 4, "\\keep\n\none\n\ntwo\n\nthree\n\n\\endkeep\n", 2, 6},
+	}
+	for _, c := range cases {
+		page, y := render(c.lines, c.markdown)
+		if page != c.page || (page > 1 && math.Abs(y-c.y) > 1e-6) {
+			t.Errorf("%v: expected to end %v lines down page %v, got %v lines down page %v", c.name, c.y, c.page, y, page)
+		}
+	}
+}
+
+// TestTabStops tests text after tabs is placed at left, right and decimal stops, with leaders filling the space before them
+func TestTabStops(t *testing.T) {
+	doc := NewDocument("tabs", "", &PdfConfig{Portrait: true, Metric: true})
+	if err := doc.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	doc.tabs = []rules.TabStop{
+		{Position: rules.Length{Value: 50}},
+		{Position: rules.Length{Value: 100}, Align: rules.TabRight, Leader: "."},
+		{Position: rules.Length{Value: 150}, Align: rules.TabDecimal},
+	}
+	runs := []textRun{{text: "a\tb\tright\t12.5\tpast", family: doc.fontFamily, size: doc.fontSize}}
+	items := doc.lineItems(runs)
+	lm, _, _, _ := doc.fpdf.GetMargins()
+	if !doc.tabWidths(items, runs, 0, lm) {
+		t.Fatal("expected the line to have tabs")
+	}
+
+	// the position of the text after each tab
+	pos := 0.0
+	var after []float64
+	var leaders []string
+	for _, it := range items {
+		if it.tab {
+			after = append(after, pos+it.width)
+			leaders = append(leaders, it.text)
+		}
+		pos += it.width
+	}
+	if strings.Join(leaders, ",") != ",.,," {
+		t.Errorf("expected only the right tab to have a leader, got %q", leaders)
+	}
+	doc.fpdf.SetFont(doc.fontFamily, "", float64(doc.fontSize))
+	width := func(s string) float64 { return doc.fpdf.GetStringWidth(s) }
+	expected := []float64{50, 100 - width("right"), 150 - width("12"), 160}
+	for i := range expected {
+		if math.Abs(after[i]-expected[i]) > 1e-6 {
+			t.Errorf("tab %v: expected text at %v, got %v", i, expected[i], after[i])
+		}
+	}
+}
+
+// TestTheme tests themes are merged with the defaults, colours are resolved, and element styles are applied and undone
+func TestTheme(t *testing.T) {
+	theme, err := ParseTheme([]byte(`{"colors": {"brand": "#0a3d62", "accent": "brand"}, "link": {"color": "accent"}, "headings": [{"fontStyle": "B"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := theme.merged(defaultTheme)
+	if merged.Link.FontStyle != "U" || merged.Link.Color != "accent" {
+		t.Errorf("expected the link style to keep the default underline with the theme colour, got %+v", merged.Link)
+	}
+	if merged.Code.Background != defaultTheme.Code.Background || merged.Headings[0].FontStyle != "B" {
+		t.Errorf("expected the theme to be merged with the defaults, got %+v", merged)
+	}
+
+	for str, expected := range map[string]rgb{"accent": {10, 61, 98}, "#c00": {204, 0, 0}, "Grey": {128, 128, 128}} {
+		c, err := parseColor(str, merged.Colors)
+		if err != nil || c != expected {
+			t.Errorf("expected %v to be %v, got %v %v", str, expected, c, err)
+		}
+	}
+	for _, str := range []string{"#12", "#ggg", "brandy"} {
+		if _, err := parseColor(str, merged.Colors); err == nil {
+			t.Errorf("expected an error for %q", str)
+		}
+	}
+
+	// element styles are undone when the element closes
+	doc := NewDocument("theme", "# Heading\n\ntext [link](http://example.com) after\n", &PdfConfig{Portrait: true, Metric: true, Theme: theme})
+	if err := doc.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if doc.fontStyle != "" || doc.fontSize != nominalFontSize || doc.textColor != (rgb{}) || len(doc.styles) != 0 {
+		t.Errorf("expected the paragraph style after the document, got %q %v %v %v", doc.fontStyle, doc.fontSize, doc.textColor, doc.styles)
+	}
+
+	// text held to be justified keeps the font of its element
+	doc = NewDocument("theme", "", &PdfConfig{Portrait: true, Metric: true, Justify: true})
+	tokens := doc.parser.Parse([]byte("text `code`\n"))
+	doc.render(tokens[1])
+	if len(doc.runs) != 2 || doc.runs[0].family != doc.fontFamily || doc.runs[1].family != defaultTheme.Code.FontFamily {
+		t.Errorf("expected the code to be held in the code font, got %+v", doc.runs)
+	}
+}
+
+// TestSpacing tests fractional font sizes and line spacing, and the space themes put around paragraphs and headings
+func TestSpacing(t *testing.T) {
+	sizes := SizesConfig{NominalFontSize: 10.5, Heading1FontSize: 16.5, LineSpacing: 1.5}
+	conf := &PdfConfig{Portrait: true, Unit: "pt", Sizes: sizes}
+	doc := NewDocument("spacing", "", conf)
+	if doc.fontSize != 10.5 || math.Abs(doc.lineHeight-15.75) > 1e-6 {
+		t.Errorf("expected a font size of 10.5pt with 15.75pt lines, got %v and %v", doc.fontSize, doc.lineHeight)
+	}
+
+	cases := []struct {
+		template string
+		theme    *Theme
+		lines    float64 // height of the content in lines of body text
+	}{
+		{"one\n\ntwo\n", nil, 4},
+		{"one\n\ntwo\n", &Theme{Paragraph: Style{SpaceAfter: -1}}, 2},
+		{"one\n\ntwo\n", &Theme{Paragraph: Style{SpaceBefore: 15.75, SpaceAfter: 7.875}}, 4},
+		{"# head\n", &Theme{Headings: [6]Style{{SpaceAfter: 31.5}}}, 3},
+	}
+	for i, c := range cases {
+		conf := &PdfConfig{Portrait: true, Unit: "pt", Sizes: sizes, Theme: c.theme}
+		doc := NewDocument("spacing", c.template, conf)
+		if err := doc.Execute(nil); err != nil {
+			t.Fatal(err)
+		}
+		_, top, _, _ := doc.fpdf.GetMargins()
+		if lines := (doc.fpdf.GetY() - top) / doc.lineHeight; math.Abs(lines-c.lines) > 1e-6 {
+			t.Errorf("case %v: expected the content to take %v lines, got %v", i, c.lines, lines)
+		}
+	}
+}
+
+// TestColorRules tests parsing colour and highlight markup, which can nest
+func TestColorRules(t *testing.T) {
+	doc := NewDocument("color", "", nil)
+	inline := func(src string) []markdown.Token {
+		for _, tok := range doc.parser.Parse([]byte(src)) {
+			if il, ok := tok.(*markdown.Inline); ok {
+				return il.Children
+			}
+		}
+		return nil
+	}
+
+	toks := inline("{color=red highlight=#ff0}a {colour=blue}b{/}{/} ==c==")
+	open, ok := toks[0].(*rules.ColorOpen)
+	if !ok || open.Color != "red" || open.Highlight != "#ff0" {
+		t.Fatalf("expected a red highlighted span, got %#v", toks[0])
+	}
+	if nested, ok := toks[2].(*rules.ColorOpen); !ok || nested.Color != "blue" {
+		t.Errorf("expected a nested blue span, got %#v", toks[2])
+	}
+	if mark, ok := toks[len(toks)-3].(*rules.ColorOpen); !ok || !mark.Mark {
+		t.Errorf("expected a highlight mark, got %#v", toks[len(toks)-3])
+	}
+
+	for _, src := range []string{"a == b", "{foo}x{/}", "{color=red}unclosed", "===x==="} {
+		for _, tok := range inline(src) {
+			if _, ok := tok.(*rules.ColorOpen); ok {
+				t.Errorf("expected %q to be left as text", src)
+			}
+		}
+	}
+}
+
+// TestScriptRules tests parsing superscript and subscript markup
+func TestScriptRules(t *testing.T) {
+	doc := NewDocument("script", "", nil)
+	inline := func(src string) []markdown.Token {
+		for _, tok := range doc.parser.Parse([]byte(src)) {
+			if il, ok := tok.(*markdown.Inline); ok {
+				return il.Children
+			}
+		}
+		return nil
+	}
+
+	toks := inline("m^2^ CO~2~")
+	var scripts []*rules.ScriptOpen
+	for _, tok := range toks {
+		if open, ok := tok.(*rules.ScriptOpen); ok {
+			scripts = append(scripts, open)
+		}
+	}
+	if len(scripts) != 2 || scripts[0].Sub || !scripts[1].Sub {
+		t.Fatalf("expected a superscript then a subscript, got %v", scripts)
+	}
+
+	for _, src := range []string{"~~struck~~", "a ~ b ~ c", "^not sup^", "a^^b"} {
+		for _, tok := range inline(src) {
+			if _, ok := tok.(*rules.ScriptOpen); ok {
+				t.Errorf("expected %q to have no superscript or subscript", src)
+			}
+		}
+	}
+}
+
+// TestFootnotes tests footnotes are numbered in the order they are referenced, and space is reserved for them at the bottom of the page
+func TestFootnotes(t *testing.T) {
+	text := "First[^b] and second[^a], then first again[^b].\n\n[^a]: Second\n[^b]: First\n    continued\n"
+	doc := NewDocument("footnotes", text, nil)
+	_, bottom := doc.fpdf.GetAutoPageBreak()
+	if err := doc.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	f := doc.footnotes
+	if len(f.notes) != 2 || f.notes[0].text != "First\ncontinued" || f.notes[1].text != "Second" {
+		t.Fatalf("expected the footnotes numbered in order of reference, got %+v", f.notes)
+	}
+	if len(f.pending) != 2 || f.height <= 0 {
+		t.Fatalf("expected both footnotes placed on the page, got %+v", f.pending)
+	}
+	if _, moved := doc.fpdf.GetAutoPageBreak(); math.Abs(moved-(bottom+f.height)) > 1e-6 {
+		t.Errorf("expected the page break moved up by %v, from %v to %v", f.height, bottom, moved)
+	}
+
+	doc = NewDocument("endnotes", text, &PdfConfig{Portrait: true, Metric: true, Endnotes: true})
+	if err := doc.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.footnotes.pending) != 0 {
+		t.Errorf("expected endnotes to leave the page bottom clear, got %+v", doc.footnotes.pending)
+	}
+}
+
+// TestDefinitionList tests parsing terms and their definitions, which can run over several lines
+func TestDefinitionList(t *testing.T) {
+	doc := NewDocument("definitions", "", nil)
+	tokens := doc.parser.Parse([]byte("Term\n: First definition\ncontinued\n: Second\n\nOther term\n: Its definition\n\nParagraph\n"))
+	var kinds, content []string
+	var list *rules.DefinitionListOpen
+	for _, tok := range tokens {
+		switch tk := tok.(type) {
+		case *rules.DefinitionListOpen:
+			list = tk
+		case *rules.DefinitionOpen, *rules.DefinitionTermOpen, *markdown.ParagraphOpen:
+			kinds = append(kinds, tok.Tag())
+		case *markdown.Inline:
+			content = append(content, tk.Content)
+		}
+	}
+	if list == nil || len(list.Terms) != 2 {
+		t.Fatalf("expected a definition list of 2 terms, got %#v", list)
+	}
+	if strings.Join(kinds, ",") != "dt,dd,dd,dt,dd,p" {
+		t.Errorf("expected two terms with their definitions followed by a paragraph, got %v", kinds)
+	}
+	if content[1] != "First definition\ncontinued" {
+		t.Errorf("expected the definition to continue on the next line, got %q", content[1])
+	}
+
+	// definitions line up clear of the widest term, with the space of a bullet indent after it
+	doc.pushStyle(Style{FontStyle: "B"})
+	expected := doc.fpdf.GetStringWidth("Other term") + doc.sizes.BulletIndent
+	doc.popStyle()
+	if indent := doc.definitionIndent(list.Terms); math.Abs(indent-expected) > 1e-6 {
+		t.Errorf("expected definitions to hang at %v, got %v", expected, indent)
+	}
+}
+
+// TestTaskList tests parsing task list items, open or checked, leaving other brackets as text
+func TestTaskList(t *testing.T) {
+	doc := NewDocument("tasks", "", nil)
+	tokens := doc.parser.Parse([]byte("- [ ] open\n- [x] **done**\n- [link]\n- \\[ ] escaped\n"))
+	var tasks []bool
+	var content []string
+	for _, tok := range tokens {
+		switch tk := tok.(type) {
+		case *rules.TaskItemOpen:
+			tasks = append(tasks, tk.Checked)
+		case *markdown.Inline:
+			content = append(content, tk.Content)
+		}
+	}
+	if len(tasks) != 2 || tasks[0] || !tasks[1] {
+		t.Fatalf("expected an open and a checked task, got %v", tasks)
+	}
+	expected := []string{"open", "**done**", "[link]", "\\[ ] escaped"}
+	if strings.Join(content, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected the task markers to be removed, got %q", content)
+	}
+}
+
+// TestCallouts tests parsing callouts and boxes, styling each kind of callout, and ending callouts left open inside a quote
+func TestCallouts(t *testing.T) {
+	doc := NewDocument("callouts", "", nil)
+	src := "> [!WARNING] Mind *the* gap\n> body text\n\n> [!note]\n\n> plain quote\n\n\\box tip\nin a box\n\\endbox\n\n\\box\nplain box\n\\endbox\n"
+	var kinds, titles, content []string
+	title := false
+	for _, tok := range doc.parser.Parse([]byte(src)) {
+		switch tk := tok.(type) {
+		case *rules.BoxOpen:
+			kinds = append(kinds, tk.Kind)
+		case *markdown.BlockquoteOpen:
+			kinds = append(kinds, "quote")
+		case *rules.BoxTitleOpen:
+			title = true
+		case *markdown.Inline:
+			if title {
+				titles = append(titles, tk.Content)
+			} else {
+				content = append(content, tk.Content)
+			}
+			title = false
+		}
+	}
+	if strings.Join(kinds, ",") != "warning,note,quote,tip," {
+		t.Errorf("expected a warning, a note, a quote, a tip and a plain box, got %q", kinds)
+	}
+	if strings.Join(titles, "|") != "Mind *the* gap|Note|Tip" {
+		t.Errorf("expected the titles of the callouts, with the kind as the default title, got %q", titles)
+	}
+	if strings.Join(content, "|") != "body text|plain quote|in a box|plain box" {
+		t.Errorf("expected the callout markers to be removed from the content, got %q", content)
+	}
+
+	// kinds of callout take what their style leaves empty from the callout style
+	theme := &Theme{Callout: Style{LineWidth: 0.5}, Callouts: map[string]Style{"Warning": {Background: "red"}}}
+	doc = NewDocument("callouts", "", &PdfConfig{Portrait: true, Metric: true, Theme: theme})
+	style, _ := doc.boxStyle(&rules.BoxOpen{Kind: "warning"})
+	expected := Style{BorderColor: defaultTheme.Callouts["warning"].BorderColor, Background: "red", LineWidth: 0.5}
+	if style != expected {
+		t.Errorf("expected the warning style %+v, got %+v", expected, style)
+	}
+
+	// a callout left open in a quote with a bar ends with the quote
+	theme = &Theme{Blockquote: Style{BorderColor: "grey"}}
+	doc = NewDocument("callouts", "> \\box note\n> inside\n\nafter\n", &PdfConfig{Portrait: true, Metric: true, Theme: theme})
+	if err := doc.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.boxes) != 0 || len(doc.styles) != 0 || math.Abs(doc.leftMargin-doc.margins.Left) > 1e-6 {
+		t.Errorf("expected the boxes, styles and margin to be restored after the quote, got %v %v %v", doc.boxes, doc.styles, doc.leftMargin)
+	}
+}
+
+// TestCodeBlocks tests reading the language of fenced code, expanding tabs, and wrapping long lines to the width of the block
+func TestCodeBlocks(t *testing.T) {
+	lang, numbered := fenceInfo("Go numbered")
+	if lang != "go" || !numbered {
+		t.Errorf("expected numbered go code, got %q %v", lang, numbered)
+	}
+
+	// tabs expand to the next tab stop, and lines split across tokens
+	lines := codeLines(highlight.Tokenize("go", "if x {\n\treturn \"a\tb\"\n}"))
+	var text []string
+	for _, line := range lines {
+		s := ""
+		for _, tok := range line {
+			s += tok.Text
+		}
+		text = append(text, s)
+	}
+	expected := []string{"if x {", "    return \"a   b\"", "}"}
+	if strings.Join(text, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected lines %q, got %q", expected, text)
+	}
+
+	// long lines wrap between characters, and each row fits the width of the block
+	doc := NewDocument("code", "", nil)
+	doc.pushStyle(doc.theme.Code)
+	width := 20 * doc.fpdf.GetStringWidth("m")
+	rows := doc.wrapCode(codeLines(highlight.Tokenize("go", strings.Repeat("x = 10 // comment ", 4)))[0], width)
+	if len(rows) != 4 {
+		t.Errorf("expected 72 characters to wrap onto 4 rows of 20, got %v rows", len(rows))
+	}
+	for i, row := range rows {
+		s := ""
+		for _, tok := range row {
+			s += tok.Text
+		}
+		if w := doc.fpdf.GetStringWidth(s); w > width+1e-6 {
+			t.Errorf("row %v is %v wide, wider than %v", i, w, width)
+		}
+	}
+}
+
+// TestHorizontalRules tests parsing styled rules alongside markdown rules, and merging the theme's line style
+func TestHorizontalRules(t *testing.T) {
+	doc := NewDocument("rules", "", nil)
+	var styles []string
+	for _, tok := range doc.parser.Parse([]byte("text\n\\hr double  short\n\n---\n\n\\hr\n\n\\hrule\n")) {
+		switch tk := tok.(type) {
+		case *rules.Hr:
+			styles = append(styles, tk.Style)
+		case *markdown.Hr:
+			styles = append(styles, "markdown")
+		}
+	}
+	if strings.Join(styles, ",") != "double short,markdown," {
+		t.Errorf("expected a styled rule, a markdown rule and a plain rule, got %q", styles)
+	}
+
+	// the theme's line style carries over from the base theme like the other style fields
+	theme := &Theme{Hr: Style{LineWidth: 0.5}}
+	merged := theme.merged(Theme{Hr: Style{LineStyle: "dashed"}})
+	if merged.Hr.LineStyle != "dashed" || merged.Hr.LineWidth != 0.5 {
+		t.Errorf("expected a dashed rule 0.5 wide, got %+v", merged.Hr)
+	}
+}
+
+// TestHTML tests parsing HTML into tags and text, applying and restoring the styles of elements, and building tables with spanning cells
+func TestHTML(t *testing.T) {
+	tokens := parseHTML(`<p align="center">a &amp; b<!-- note --><br/><IMG SRC='x.png' width=20></p> 1 < 2`)
+	var parsed []string
+	for _, tok := range tokens {
+		switch {
+		case tok.tag == "":
+			parsed = append(parsed, tok.text)
+		case tok.closing:
+			parsed = append(parsed, "/"+tok.tag)
+		default:
+			parsed = append(parsed, tok.tag+" "+fmt.Sprint(tok.attrs))
+		}
+	}
+	expected := []string{"p map[align:center]", "a & b", "br map[]", "img map[src:x.png width:20]", "/p", " 1 < 2"}
+	if strings.Join(parsed, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, parsed)
+	}
+
+	// styles apply until their end tag, or the end of the text they are in, and spanning table cells merge their neighbours
+	doc := NewDocument("html", "", nil)
+	doc.renderHTML(`<span style="font-weight: bold; color: rgb(255, 0, 0)"><i>`)
+	if doc.fontStyle != "BI" || doc.textColor != (rgb{255, 0, 0}) {
+		t.Errorf("expected bold italic red text, got %q %v", doc.fontStyle, doc.textColor)
+	}
+	doc.closeHTML(0)
+	if doc.fontStyle != "" || doc.textColor != (rgb{}) || len(doc.html.open) != 0 {
+		t.Errorf("expected the style to be restored, got %q %v", doc.fontStyle, doc.textColor)
+	}
+	doc.renderHTML("<table>\n<tr><th rowspan=2>a</th><td colspan=2> b </td></tr>\n<tr><td>c<td>&lt;\n</table>")
+	var cells []string
+	for _, row := range doc.table.rows {
+		for _, c := range row {
+			cells = append(cells, c.text)
+		}
+		cells = append(cells, "|")
+	}
+	if strings.Join(cells, " ") != "a b < | ^ c < |" {
+		t.Errorf("expected the spans to be merged, got %q", cells)
+	}
+	if doc.table.rows[1][2].colSpan != 1 {
+		t.Errorf("expected the text of an HTML cell to never merge it, got a span of %v", doc.table.rows[1][2].colSpan)
+	}
+}
+
 // OutputExamplePdf is a test method to generate an extremely simple pdf to test initial generation
 func OutputExamplePdf(destination string) error {
 	template := `{#% test marker %#}
@@ -38,7 +966,9 @@ blah
 blah
 blah
 
-\thead ln shalf ac
+\tcaption{first} a captioned table, see also table \tref{spans}
+
+\thead ln shalf ac p2mm:1.5mm vm
 
 | a | b |
 | --- | --- |
@@ -52,7 +982,20 @@ blah
 | test table | with cells |
 | and more | cells |
 
-blah
+\thead lt s80% al c:40mm:1:1
+
+| section | < | < |
+| --- | --- | --- |
+| merged rows | a | b |
+| ^ | c | d |
+| wide cell spanning two columns | < | e |
+
+\tcaption{spans} merged cells, following table \tref{first}
+
+blah, as shown in table \tref{spans}
+
+\thead lt sfull c:3:1:1
+{{table .items (column "Name" "Item") (column "Qty" "Quantity") (column "Price" "Price" "currency") (totals "Total" "Qty" "Price")}}
 
 blah
 
@@ -87,6 +1030,10 @@ blah!`
 
 	inputMap := map[string]interface{}{
 		"include": "Im template included content",
+		"items": []map[string]interface{}{
+			{"Name": "widget | large", "Qty": 2, "Price": 10.5},
+			{"Name": "gadget\nwith notes", "Qty": 1, "Price": 4.25},
+		},
 	}
 
 	doc.AddExtensionFunctions(map[string]interface{}{
@@ -0,0 +1,281 @@
+package docgen
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/Maldris/commonmarkDocgen/rules"
+	"github.com/jung-kurt/gofpdf"
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// footnote is a referenced footnote, with the markdown of its text
+type footnote struct {
+	number int
+	text   string
+	offset float64 // height of the footnote already written on earlier pages
+	height float64 // height of the footnote written on the current page, once placed
+}
+
+// numberFootnotes records the footnotes defined in tokens, and numbers them in the order they are first referenced
+func (d *Document) numberFootnotes(tokens []markdown.Token) {
+	f := &d.footnotes
+	for _, tok := range tokens {
+		tk, ok := tok.(*rules.Footnote)
+		if !ok {
+			continue
+		}
+		if _, ok := f.text[tk.Label]; ok {
+			fmt.Printf("[docgen] Footnote defined more than once: %v\r\n", tk.Label)
+			continue
+		}
+		f.text[tk.Label] = tk.Text
+	}
+	for _, tok := range tokens {
+		il, ok := tok.(*markdown.Inline)
+		if !ok {
+			continue
+		}
+		for _, child := range il.Children {
+			ref, ok := child.(*rules.FootnoteReference)
+			if !ok {
+				continue
+			}
+			text, ok := f.text[ref.Label]
+			if !ok {
+				fmt.Printf("[docgen] Reference to undefined footnote: %v\r\n", ref.Label)
+				continue
+			}
+			if f.labels[ref.Label] == 0 {
+				f.count++
+				f.labels[ref.Label] = f.count
+				f.notes = append(f.notes, footnote{number: f.count, text: text})
+			}
+			ref.Number = f.labels[ref.Label]
+		}
+	}
+}
+
+// footnoteReference writes the number of a footnote raised as superscript, with the footnote placed on the page once the line it is on is laid out
+func (d *Document) footnoteReference(ref *rules.FootnoteReference) {
+	if ref.Number == 0 {
+		d.write("[^"+ref.Label+"]", "")
+		return
+	}
+	number := strconv.Itoa(ref.Number)
+	if d.writeMode == tableHead || d.writeMode == tableCell {
+		// table cells are plain text, so the number is written in brackets
+		row := d.table.rows[len(d.table.rows)-1]
+		row[len(row)-1].text += "[" + number + "]"
+		d.placeFootnote(ref.Label)
+		return
+	}
+	d.render(&rules.ScriptOpen{})
+	// raised text is always held, so its run can carry the footnote to place
+	d.write(number, "")
+	d.runs[len(d.runs)-1].footnote = ref.Label
+	d.render(&rules.ScriptClose{})
+}
+
+// placeFootnote reserves space at the bottom of the page for a footnote the first time it is referenced,
+// below the current line, with any of it that doesn't fit carried over to the next page
+func (d *Document) placeFootnote(label string) {
+	f := &d.footnotes
+	if d.measuring || d.pageTemplate || f.endnotes || f.placed[label] || f.labels[label] == 0 {
+		return
+	}
+	f.placed[label] = true
+	note := footnote{number: f.labels[label], text: f.text[label]}
+	if len(f.carried) > 0 {
+		// footnotes keep their order, so follow those already waiting for the next page
+		f.carried = append(f.carried, note)
+		return
+	}
+	d.fitFootnote(note, d.pageBottom()-(d.fpdf.GetY()+d.lineHeight))
+}
+
+// fitFootnote reserves space for as much of a footnote as fits in space at the bottom of the page, in whole lines,
+// carrying the rest over to the next page, and reports if all of it fit
+func (d *Document) fitFootnote(note footnote, space float64) bool {
+	f := &d.footnotes
+	gap := 0.0
+	if len(f.pending) == 0 {
+		gap = d.footnoteGap()
+	}
+	note.height = d.footnoteHeight(note) - note.offset
+	fits := gap+note.height <= space
+	if !fits {
+		line := d.footnoteGap()
+		note.height = math.Floor((space-gap)/line+1e-6) * line
+		if note.height < line {
+			f.carried = append(f.carried, note)
+			return false
+		}
+		f.carried = append(f.carried, footnote{number: note.number, text: note.text, offset: note.offset + note.height})
+	}
+	f.pending = append(f.pending, note)
+	f.height += gap + note.height
+	d.moveBreak(gap + note.height)
+	return fits
+}
+
+// writeFootnotes writes the footnotes placed on the page in the space reserved for them above bottom,
+// then reserves space on the next page for the footnotes carried over to it
+func (d *Document) writeFootnotes(bottom float64) {
+	f := &d.footnotes
+	if len(f.pending) > 0 {
+		x, y := d.fpdf.GetXY()
+		lm, _, rm, _ := d.fpdf.GetMargins()
+		d.fpdf.SetLeftMargin(d.margins.Left)
+		d.fpdf.SetRightMargin(d.margins.Right)
+		wd, _ := d.fpdf.GetPageSize()
+		top := bottom - f.height
+		d.footnoteRule(top + d.footnoteGap()/2)
+		at := top + d.footnoteGap()
+		m := d.footnoteDocument(d.fpdf)
+		for _, note := range f.pending {
+			// the footnote is clipped to its space, so only the part of it placed on this page shows
+			d.fpdf.ClipRect(d.margins.Left, at, wd-(d.margins.Left+d.margins.Right), note.height, false)
+			d.fpdf.SetXY(d.margins.Left, at-note.offset)
+			m.writeFootnote(note)
+			d.fpdf.ClipEnd()
+			at += note.height
+		}
+		d.fpdf.SetLeftMargin(lm)
+		d.fpdf.SetRightMargin(rm)
+		d.fpdf.SetXY(x, y)
+		d.flushTextStyling()
+	}
+	d.moveBreak(-f.height)
+	f.pending, f.height = nil, 0
+
+	// carried footnotes go on the next page, leaving room for some of its content
+	_, pageh := d.fpdf.GetPageSize()
+	_, pageBottom := d.fpdf.GetAutoPageBreak()
+	avail := pageh - d.margins.Top - pageBottom - minLines*d.lineHeight
+	carried := f.carried
+	f.carried = nil
+	for i, note := range carried {
+		if !d.fitFootnote(note, avail-f.height) {
+			f.carried = append(f.carried, carried[i+1:]...)
+			break
+		}
+	}
+}
+
+// finishFootnotes writes the endnotes at the end of the document, or adds a page for footnotes carried past the last page
+func (d *Document) finishFootnotes() {
+	f := &d.footnotes
+	if !f.endnotes {
+		for len(f.carried) > 0 {
+			next := f.carried[0]
+			d.addPage()
+			if len(f.carried) > 0 && f.carried[0] == next {
+				// there is no room for any more of the footnotes on a page
+				break
+			}
+		}
+		return
+	}
+	if len(f.notes) == 0 {
+		return
+	}
+	d.keep(d.footnoteGap() + d.footnoteHeight(f.notes[0]))
+	y := d.fpdf.GetY()
+	d.footnoteRule(y + d.footnoteGap()/2)
+	d.fpdf.SetY(y + d.footnoteGap())
+	m := d.footnoteDocument(d.fpdf)
+	for _, note := range f.notes {
+		m.writeFootnote(note)
+	}
+	d.flushTextStyling()
+}
+
+// moveBreak moves the page break up by height to leave space at the bottom of the page, or back down if height is negative
+func (d *Document) moveBreak(height float64) {
+	if height == 0 {
+		return
+	}
+	if d.columns.count > 1 {
+		d.columns.bottom += height
+	}
+	if d.earlyBreak.set {
+		d.earlyBreak.bottom += height
+	}
+	_, bottom := d.fpdf.GetAutoPageBreak()
+	switch {
+	case d.earlyBreak.set:
+		bottom = math.Max(bottom, d.earlyBreak.bottom)
+	case d.columns.count > 1:
+		// columns ending above the footnotes keep their balanced height
+		bottom = math.Max(bottom, d.columns.bottom)
+	default:
+		bottom += height
+	}
+	d.fpdf.SetAutoPageBreak(true, bottom)
+}
+
+// footnoteDocument creates a document writing footnotes to pdf in the footnote style, from the left margin of pdf
+func (d *Document) footnoteDocument(pdf *gofpdf.Fpdf) *Document {
+	m := d.subDocument(pdf)
+	m.leftMargin, _, _, _ = pdf.GetMargins()
+	style := d.theme.Footnote
+	if style.FontSize == 0 {
+		style.FontSize = d.sizes.FootnoteFontSize
+	}
+	// paragraphs in footnotes are spaced by the footnote style, which leaves no space by default
+	m.theme.Paragraph.SpaceBefore = style.SpaceBefore
+	m.theme.Paragraph.SpaceAfter = style.SpaceAfter
+	if style.SpaceAfter == 0 {
+		m.theme.Paragraph.SpaceAfter = -1
+	}
+	m.setStyle(d.theme.Paragraph)
+	m.setStyle(style)
+	return m
+}
+
+// writeFootnote writes a footnote, starting with its number raised as superscript
+func (d *Document) writeFootnote(note footnote) {
+	for _, tok := range d.parser.Parse([]byte(fmt.Sprintf("^%d^ %s", note.number, note.text))) {
+		d.render(tok)
+	}
+	d.flushRuns()
+}
+
+// footnoteHeight measures the height of a footnote written across the page
+func (d *Document) footnoteHeight(note footnote) float64 {
+	wd, _ := d.fpdf.GetPageSize()
+	pdf := d.scratchPdf(wd - (d.margins.Left + d.margins.Right))
+	m := d.footnoteDocument(pdf)
+	m.measuring = true
+	m.writeFootnote(note)
+	return pdf.GetY()
+}
+
+// footnoteGap gives the height of a line of footnote text, which is also the space left above footnotes,
+// with the rule separating them from the content above drawn across it
+func (d *Document) footnoteGap() float64 {
+	size := d.theme.Footnote.FontSize
+	if size == 0 {
+		size = d.sizes.FootnoteFontSize
+	}
+	return size * d.sizes.LineSpacing / d.fpdf.GetConversionRatio()
+}
+
+// footnoteRule draws the short rule separating footnotes from the content above them at y
+func (d *Document) footnoteRule(y float64) {
+	wd, _ := d.fpdf.GetPageSize()
+	width := d.fpdf.GetLineWidth()
+	r, g, b := d.fpdf.GetDrawColor()
+	if d.theme.Footnote.LineWidth > 0 {
+		d.fpdf.SetLineWidth(d.theme.Footnote.LineWidth)
+	}
+	if c, ok := d.color(d.theme.Footnote.BorderColor); ok {
+		d.fpdf.SetDrawColor(c.r, c.g, c.b)
+	}
+	left := d.margins.Left
+	d.fpdf.Line(left, y, left+(wd-(left+d.margins.Right))/3, y)
+	d.fpdf.SetLineWidth(width)
+	d.fpdf.SetDrawColor(r, g, b)
+}
@@ -25,7 +25,11 @@ func GetFunctionMap(exts template.FuncMap) template.FuncMap {
 		"cb":       codeBlock,
 		"dict":     dictionary,
 		"yn":       boolString,
+		"check":    checkbox,
+		"column":   newTableColumn,
+		"totals":   newTableTotals,
 	}
+	funcMap["table"] = tableFunc(funcMap)
 
 	for key, val := range exts {
 		funcMap[key] = val
@@ -20,3 +20,10 @@ func title(str string) string {
 func boolString(b bool) string {
 	return strconv.FormatBool(b)
 }
+
+func checkbox(b bool) string {
+	if b {
+		return "[x]"
+	}
+	return "[ ]"
+}
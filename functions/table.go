@@ -0,0 +1,274 @@
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// tableColumn describes one column of a generated table, the field to read from each row, its heading, and an optional format function name
+type tableColumn struct {
+	field   string
+	heading string
+	format  string
+}
+
+// tableTotals describes a totals row, printing label in the first column and the sum of each named field
+type tableTotals struct {
+	label  string
+	fields []string
+}
+
+func newTableColumn(field string, args ...string) (*tableColumn, error) {
+	col := &tableColumn{field: field, heading: field}
+	switch len(args) {
+	case 0:
+	case 1:
+		col.heading = args[0]
+	case 2:
+		col.heading = args[0]
+		col.format = args[1]
+	default:
+		return nil, fmt.Errorf("wrong number of args: want 1 to 3, got %v", len(args)+1)
+	}
+	return col, nil
+}
+
+func newTableTotals(label string, fields ...string) *tableTotals {
+	return &tableTotals{label: label, fields: fields}
+}
+
+// tableFunc builds the table template function, resolving format functions by name from funcs when executed
+func tableFunc(funcs template.FuncMap) func(rows interface{}, spec ...interface{}) (string, error) {
+	return func(rows interface{}, spec ...interface{}) (string, error) {
+		list := reflect.Indirect(reflect.ValueOf(rows))
+		if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+			return "", fmt.Errorf("table rows must be a slice or array, got %T", rows)
+		}
+
+		var cols []*tableColumn
+		var totals *tableTotals
+		for _, s := range spec {
+			switch s := s.(type) {
+			case *tableColumn:
+				cols = append(cols, s)
+			case *tableTotals:
+				totals = s
+			default:
+				return "", fmt.Errorf("table arguments must be columns or totals, got %T", s)
+			}
+		}
+		if len(cols) == 0 {
+			if list.Len() == 0 {
+				return "", errors.New("table needs columns when there are no rows")
+			}
+			for _, field := range tableFields(list.Index(0)) {
+				cols = append(cols, &tableColumn{field: field, heading: field})
+			}
+		}
+
+		format := func(col *tableColumn, val interface{}) (string, error) {
+			if col.format == "" {
+				if val == nil {
+					return "", nil
+				}
+				return fmt.Sprint(val), nil
+			}
+			fn, ok := funcs[col.format]
+			if !ok {
+				return "", fmt.Errorf("table column %v: unknown format function %v", col.field, col.format)
+			}
+			return callFormat(fn, val)
+		}
+
+		buf := new(strings.Builder)
+		row := make([]string, len(cols))
+		for i, col := range cols {
+			row[i] = col.heading
+		}
+		writeTableRow(buf, row)
+		for i := range row {
+			row[i] = "---"
+		}
+		buf.WriteString("| " + strings.Join(row, " | ") + " |\n")
+
+		sums := map[string]float64{}
+		for r := 0; r < list.Len(); r++ {
+			for i, col := range cols {
+				val, err := tableField(list.Index(r), col.field)
+				if err != nil {
+					return "", err
+				}
+				if row[i], err = format(col, val); err != nil {
+					return "", err
+				}
+				if f, ok := toFloat(val); ok {
+					sums[col.field] += f
+				}
+			}
+			writeTableRow(buf, row)
+		}
+
+		if totals != nil {
+			label := -1
+			for i, col := range cols {
+				row[i] = ""
+				summed := false
+				for _, field := range totals.fields {
+					if field != col.field {
+						continue
+					}
+					summed = true
+					var err error
+					if row[i], err = format(col, sums[field]); err != nil {
+						return "", err
+					}
+				}
+				if !summed && label < 0 {
+					label = i
+				}
+			}
+			// the label goes in the first column that isn't summed, and is left out if they all are
+			if label >= 0 {
+				row[label] = totals.label
+			}
+			writeTableRow(buf, row)
+		}
+		return buf.String(), nil
+	}
+}
+
+// writeTableRow writes a markdown table row, escaping the cell contents so they print literally
+func writeTableRow(buf *strings.Builder, row []string) {
+	buf.WriteString("|")
+	for _, val := range row {
+		buf.WriteString(" ")
+		for _, r := range strings.TrimSpace(val) {
+			switch {
+			case r == '\r':
+			case r == '\n':
+				// written as the literal \n table cells use for line breaks
+				buf.WriteString("\\n")
+			case isMarkdownPunct(r):
+				buf.WriteRune('\\')
+				buf.WriteRune(r)
+			default:
+				buf.WriteRune(r)
+			}
+		}
+		buf.WriteString(" |")
+	}
+	buf.WriteString("\n")
+}
+
+func isMarkdownPunct(r rune) bool {
+	return r < 0x7f && strings.ContainsRune("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", r)
+}
+
+// tableFields lists the fields of a row to use as columns when none are given, exported struct fields in order, or sorted map keys
+func tableFields(row reflect.Value) []string {
+	row = indirect(row)
+	var fields []string
+	switch row.Kind() {
+	case reflect.Struct:
+		for i := 0; i < row.NumField(); i++ {
+			if row.Type().Field(i).PkgPath == "" {
+				fields = append(fields, row.Type().Field(i).Name)
+			}
+		}
+	case reflect.Map:
+		for _, key := range row.MapKeys() {
+			fields = append(fields, fmt.Sprint(key.Interface()))
+		}
+		sort.Strings(fields)
+	}
+	return fields
+}
+
+// tableField reads a field of a row by name, following dots into nested structs and maps
+func tableField(row reflect.Value, field string) (interface{}, error) {
+	val := row
+	for _, name := range strings.Split(field, ".") {
+		val = indirect(val)
+		switch val.Kind() {
+		case reflect.Struct:
+			val = val.FieldByName(name)
+			if !val.IsValid() {
+				return nil, fmt.Errorf("table field %v not found", field)
+			}
+		case reflect.Map:
+			if val.Type().Key().Kind() != reflect.String {
+				return nil, fmt.Errorf("table field %v: can't read %v from %v", field, name, val.Type())
+			}
+			val = val.MapIndex(reflect.ValueOf(name).Convert(val.Type().Key()))
+			if !val.IsValid() {
+				return nil, nil
+			}
+		case reflect.Invalid:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("table field %v: can't read %v from %v", field, name, val.Type())
+		}
+	}
+	val = indirect(val)
+	if !val.IsValid() || !val.CanInterface() {
+		return nil, nil
+	}
+	return val.Interface(), nil
+}
+
+// indirect follows pointers and interfaces to the underlying value
+func indirect(val reflect.Value) reflect.Value {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return reflect.Value{}
+		}
+		val = val.Elem()
+	}
+	return val
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// callFormat calls a template function with a single value, converting the value to the parameter type where possible
+func callFormat(fn interface{}, val interface{}) (string, error) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.Type().NumIn() != 1 {
+		return "", fmt.Errorf("table format function must take a single argument, got %T", fn)
+	}
+	in := f.Type().In(0)
+	arg := reflect.ValueOf(val)
+	switch {
+	case !arg.IsValid():
+		arg = reflect.Zero(in)
+	case arg.Type().AssignableTo(in):
+	case arg.Type().ConvertibleTo(in) && arg.Kind() != reflect.String && in.Kind() != reflect.String:
+		arg = arg.Convert(in)
+	default:
+		return "", fmt.Errorf("table format function takes %v, got %T", in, val)
+	}
+	out := f.Call([]reflect.Value{arg})
+	if len(out) == 2 && !out[1].IsNil() {
+		if err, ok := out[1].Interface().(error); ok {
+			return "", err
+		}
+	}
+	if len(out) == 0 {
+		return "", nil
+	}
+	return fmt.Sprint(out[0].Interface()), nil
+}
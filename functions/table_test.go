@@ -0,0 +1,75 @@
+package functions
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+)
+
+func TestTable(t *testing.T) {
+	type item struct {
+		Name  string
+		Qty   int
+		Price float64
+	}
+	data := map[string]interface{}{
+		"items": []item{
+			{Name: "widget | large", Qty: 2, Price: 10.5},
+			{Name: "*special*\nline", Qty: 1, Price: 4},
+		},
+		"rows": []map[string]interface{}{
+			{"b": 2, "a": "x"},
+		},
+		"ops": []map[string]interface{}{
+			{"op": "<", "next": "^"},
+		},
+	}
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{
+			`{{table .items (column "Name") (column "Qty" "Quantity") (column "Price" "Price" "currency") (totals "Total" "Qty" "Price")}}`,
+			"| Name | Quantity | Price |\n" +
+				"| --- | --- | --- |\n" +
+				"| widget \\| large | 2 | \\$10\\.50 |\n" +
+				"| \\*special\\*\\nline | 1 | \\$4\\.00 |\n" +
+				"| Total | 3 | \\$14\\.50 |\n",
+		},
+		{
+			`{{table .rows}}`,
+			"| a | b |\n" +
+				"| --- | --- |\n" +
+				"| x | 2 |\n",
+		},
+		{
+			// the label moves along to the first column that isn't summed
+			`{{table .items (column "Qty") (column "Name") (totals "Total" "Qty")}}`,
+			"| Qty | Name |\n" +
+				"| --- | --- |\n" +
+				"| 2 | widget \\| large |\n" +
+				"| 1 | \\*special\\*\\nline |\n" +
+				"| 3 | Total |\n",
+		},
+		{
+			// values that are merge markers are escaped so they print rather than merge the cell
+			`{{table .ops (column "op") (column "next")}}`,
+			"| op | next |\n" +
+				"| --- | --- |\n" +
+				"| \\< | \\^ |\n",
+		},
+	}
+	for _, test := range tests {
+		tmp, err := template.New("table").Funcs(GetFunctionMap(nil)).Parse(test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err = tmp.Execute(buf, data); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("expected:\n%v\ngot:\n%v", test.expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package highlight splits source code into tokens by their syntax, so code blocks can be coloured by the language they are written in
+package highlight
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind is the kind of syntax a token is, used to pick the style it is written in
+type Kind string
+
+// the kinds of token, Plain for anything without a style of its own, such as punctuation and names
+const (
+	Plain   Kind = ""
+	Keyword Kind = "keyword"
+	Type    Kind = "type"
+	Literal Kind = "literal" // built in values such as true, false and null
+	String  Kind = "string"
+	Number  Kind = "number"
+	Comment Kind = "comment"
+	Key     Kind = "key" // the names of the members of JSON objects
+)
+
+// Token is a run of source code of one kind
+type Token struct {
+	Kind Kind
+	Text string
+}
+
+// quote is a kind of quoted string in a language, and how a quote mark is escaped within it
+type quote struct {
+	mark      byte
+	backslash bool // a backslash escapes the next character, otherwise a doubled quote mark is the escape
+	multiline bool // the string can run over several lines
+}
+
+// language is the syntax of a language, as much as is needed to colour it
+type language struct {
+	lineComments  []string
+	blockComments [][2]string
+	quotes        []quote
+	keywords      map[string]bool
+	types         map[string]bool
+	literals      map[string]bool
+	ignoreCase    bool // keywords, types and literals match in any case
+	keys          bool // strings followed by a colon are the names of object members
+}
+
+// words gives a set of the space separated words of list
+func words(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(list) {
+		set[w] = true
+	}
+	return set
+}
+
+var golang = &language{
+	lineComments:  []string{"//"},
+	blockComments: [][2]string{{"/*", "*/"}},
+	quotes:        []quote{{mark: '"', backslash: true}, {mark: '\'', backslash: true}, {mark: '`', multiline: true}},
+	keywords: words(`break case chan const continue default defer else fallthrough for func go goto if import interface map package
+		range return select struct switch type var`),
+	types: words(`any bool byte comparable complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string
+		uint uint8 uint16 uint32 uint64 uintptr`),
+	literals: words("true false nil iota"),
+}
+
+var sql = &language{
+	lineComments:  []string{"--"},
+	blockComments: [][2]string{{"/*", "*/"}},
+	quotes:        []quote{{mark: '\'', multiline: true}, {mark: '"'}},
+	keywords: words(`add all alter and as asc begin between by cascade case check column commit constraint create cross database default
+		delete desc distinct drop else end exists foreign from full group having if in index inner insert intersect into is join key
+		left like limit not offset on or order outer primary references replace returning right rollback select set table then
+		transaction truncate union unique update using values view when where with`),
+	types: words(`bigint binary bit blob boolean bool char date datetime decimal double float int integer interval json jsonb money
+		numeric real serial smallint text time timestamp tinyint uuid varchar`),
+	literals:   words("null true false"),
+	ignoreCase: true,
+}
+
+var json = &language{
+	quotes:   []quote{{mark: '"', backslash: true}},
+	literals: words("true false null"),
+	keys:     true,
+}
+
+// languages are the languages understood, by the names used for them in the info string of fenced code blocks
+var languages = map[string]*language{
+	"go":         golang,
+	"golang":     golang,
+	"sql":        sql,
+	"mysql":      sql,
+	"postgresql": sql,
+	"postgres":   sql,
+	"sqlite":     sql,
+	"json":       json,
+	"jsonc":      json,
+}
+
+// Supported reports if code in lang, named as in the info string of a fenced code block, can be highlighted
+func Supported(lang string) bool {
+	return languages[strings.ToLower(lang)] != nil
+}
+
+// Tokenize splits src, written in lang, into tokens by their syntax,
+// giving the whole of src as one plain token if the language isn't supported
+func Tokenize(lang, src string) []Token {
+	l := languages[strings.ToLower(lang)]
+	if l == nil {
+		if src == "" {
+			return nil
+		}
+		return []Token{{Plain, src}}
+	}
+	var tokens []Token
+	add := func(kind Kind, text string) {
+		if n := len(tokens); n > 0 && tokens[n-1].Kind == kind {
+			tokens[n-1].Text += text
+			return
+		}
+		tokens = append(tokens, Token{kind, text})
+	}
+	for pos := 0; pos < len(src); {
+		kind, length := l.next(src[pos:], pos > 0 && isWord(src[pos-1]))
+		add(kind, src[pos:pos+length])
+		pos += length
+	}
+	return tokens
+}
+
+// next reads the token at the start of src, giving its kind and length, with inWord set if src follows a letter or digit
+func (l *language) next(src string, inWord bool) (Kind, int) {
+	for _, c := range l.lineComments {
+		if strings.HasPrefix(src, c) {
+			if end := strings.IndexByte(src, '\n'); end >= 0 {
+				return Comment, end
+			}
+			return Comment, len(src)
+		}
+	}
+	for _, c := range l.blockComments {
+		if strings.HasPrefix(src, c[0]) {
+			if end := strings.Index(src[len(c[0]):], c[1]); end >= 0 {
+				return Comment, len(c[0]) + end + len(c[1])
+			}
+			return Comment, len(src)
+		}
+	}
+	for _, q := range l.quotes {
+		if src[0] == q.mark {
+			length := q.length(src)
+			if l.keys && isKey(src[length:]) {
+				return Key, length
+			}
+			return String, length
+		}
+	}
+	switch c := src[0]; {
+	case inWord:
+	case isDigit(c), (c == '.' || c == '-') && len(src) > 1 && isDigit(src[1]):
+		return Number, numberLength(src)
+	case isWord(c):
+		length := 1
+		for length < len(src) && isWord(src[length]) {
+			length++
+		}
+		word := src[:length]
+		if l.ignoreCase {
+			word = strings.ToLower(word)
+		}
+		switch {
+		case l.keywords[word]:
+			return Keyword, length
+		case l.types[word]:
+			return Type, length
+		case l.literals[word]:
+			return Literal, length
+		}
+		return Plain, length
+	}
+	_, length := utf8.DecodeRuneInString(src)
+	return Plain, length
+}
+
+// length gives the length of the string quoted by q at the start of src, up to the end of the line if it isn't closed
+func (q quote) length(src string) int {
+	for i := 1; i < len(src); i++ {
+		switch {
+		case src[i] == '\\' && q.backslash:
+			i++
+		case src[i] == '\n' && !q.multiline:
+			return i
+		case src[i] == q.mark:
+			if !q.backslash && i+1 < len(src) && src[i+1] == q.mark {
+				// a doubled quote mark
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(src)
+}
+
+// isKey reports if the rest of the source after a string starts with a colon, making the string the name of an object member
+func isKey(rest string) bool {
+	return strings.HasPrefix(strings.TrimLeft(rest, " \t"), ":")
+}
+
+// numberLength gives the length of the number at the start of src, including any sign, decimal point, exponent or suffix
+func numberLength(src string) int {
+	i := 0
+	if src[0] == '-' {
+		i++
+	}
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case isWord(c) || c == '.':
+			i++
+		case (c == '+' || c == '-') && (src[i-1] == 'e' || src[i-1] == 'E') && !strings.HasPrefix(strings.ToLower(src), "0x"):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isWord reports if c can be part of a name or keyword, any byte of a multi-byte character counting as part of a name
+func isWord(c byte) bool {
+	return c == '_' || c >= utf8.RuneSelf || unicode.IsLetter(rune(c)) || isDigit(c)
+}
@@ -0,0 +1,49 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+)
+
+// marked writes the tokens of src with each token that isn't plain marked with its kind, as kind(text)
+func marked(lang, src string) string {
+	var b strings.Builder
+	for _, tok := range Tokenize(lang, src) {
+		if tok.Kind == Plain {
+			b.WriteString(tok.Text)
+			continue
+		}
+		b.WriteString(string(tok.Kind) + "(" + tok.Text + ")")
+	}
+	return b.String()
+}
+
+func TestTokenize(t *testing.T) {
+	for _, test := range []struct {
+		lang, src, want string
+	}{
+		{"go", "func f(s string) int { return 0x1F } // done",
+			"keyword(func) f(s type(string)) type(int) { keyword(return) number(0x1F) } comment(// done)"},
+		{"Go", "x := `raw\n\"text` + \"a\\\"b\" /* c */ + nil",
+			"x := string(`raw\n\"text`) + string(\"a\\\"b\") comment(/* c */) + literal(nil)"},
+		{"sql", "SELECT name FROM users WHERE id = 1.5e-3 AND note = 'it''s' -- why\nLIMIT 10;",
+			"keyword(SELECT) name keyword(FROM) users keyword(WHERE) id = number(1.5e-3) keyword(AND) note = string('it''s') comment(-- why)\nkeyword(LIMIT) number(10);"},
+		{"json", `{"a": [1, -2.5, true, null], "b" : "c"}`,
+			`{key("a"): [number(1), number(-2.5), literal(true), literal(null)], key("b") : string("c")}`},
+		{"cobol", "MOVE 1 TO X", "MOVE 1 TO X"},
+	} {
+		if got := marked(test.lang, test.src); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.lang, test.want, got)
+		}
+	}
+
+	// the tokens always make up the whole of the source
+	src := "\"unclosed\nx := 'y' /* open"
+	var joined strings.Builder
+	for _, tok := range Tokenize("go", src) {
+		joined.WriteString(tok.Text)
+	}
+	if joined.String() != src {
+		t.Errorf("expected the tokens to cover the source, got %q", joined.String())
+	}
+}
@@ -0,0 +1,703 @@
+package docgen
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Maldris/commonmarkDocgen/rules"
+	"github.com/jung-kurt/gofpdf"
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// htmlToken is a tag or a run of text in HTML
+type htmlToken struct {
+	tag     string            // lower case name of the tag, empty for text
+	text    string            // text with its character references decoded
+	attrs   map[string]string // attributes of an opening tag by lower case name
+	closing bool              // an end tag
+}
+
+// htmlElement is an HTML element open around the content being written, and what to undo when it closes
+type htmlElement struct {
+	name      string
+	alignment alignment // alignment to return to
+	href      string    // link target to return to
+	close     func()    // ends the element, before its style is popped
+}
+
+// htmlSpan is a table cell spanning down into the rows below, for the column it covers
+type htmlSpan struct {
+	rows   int    // rows still to be covered
+	marker string // cell text merging the column into the spanning cell
+}
+
+var (
+	htmlTagPattern  = regexp.MustCompile(`^<(/?)([A-Za-z][A-Za-z0-9]*)((?:\s+[^\s"'>/=]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*)\s*/?>`)
+	htmlAttrPattern = regexp.MustCompile(`([^\s"'>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+	htmlSpace       = regexp.MustCompile(`\s+`)
+)
+
+// htmlVoid are the elements without content or an end tag
+var htmlVoid = map[string]bool{"br": true, "hr": true, "img": true, "wbr": true, "meta": true, "link": true, "col": true, "input": true}
+
+// htmlFontSizes are the font sizes in points of the sizes 1 to 7 of the font tag
+var htmlFontSizes = [...]float64{7.5, 10, 12, 13.5, 18, 24, 36}
+
+// parseHTML splits HTML into tags and the text between them, skipping comments, doctypes and processing instructions
+func parseHTML(src string) []htmlToken {
+	var tokens []htmlToken
+	text := ""
+	for len(src) > 0 {
+		i := strings.IndexByte(src, '<')
+		if i < 0 {
+			text += src
+			break
+		}
+		text += src[:i]
+		src = src[i:]
+		skip := ""
+		switch {
+		case strings.HasPrefix(src, "<!--"):
+			skip = "-->"
+		case strings.HasPrefix(src, "<!"), strings.HasPrefix(src, "<?"):
+			skip = ">"
+		}
+		if skip != "" {
+			end := strings.Index(src, skip)
+			if end < 0 {
+				break
+			}
+			src = src[end+len(skip):]
+			continue
+		}
+		m := htmlTagPattern.FindStringSubmatch(src)
+		if m == nil {
+			// a < that doesn't start a tag is text
+			text += "<"
+			src = src[1:]
+			continue
+		}
+		if text != "" {
+			tokens = append(tokens, htmlToken{text: html.UnescapeString(text)})
+			text = ""
+		}
+		tok := htmlToken{tag: strings.ToLower(m[2]), closing: m[1] == "/", attrs: map[string]string{}}
+		for _, a := range htmlAttrPattern.FindAllStringSubmatch(m[3], -1) {
+			tok.attrs[strings.ToLower(a[1])] = html.UnescapeString(a[2] + a[3] + a[4])
+		}
+		tokens = append(tokens, tok)
+		src = src[len(m[0]):]
+	}
+	if text != "" {
+		tokens = append(tokens, htmlToken{text: html.UnescapeString(text)})
+	}
+	return tokens
+}
+
+// renderHTML writes HTML, applying the tags it supports to the document's styling, and writing the text between them as any other text,
+// elements stay open until their end tag, or the end of the text they are in for inline HTML, and unsupported tags are reported and skipped
+func (d *Document) renderHTML(src string) {
+	for _, tok := range parseHTML(src) {
+		switch {
+		case tok.tag == "":
+			d.htmlText(tok.text)
+		case tok.closing:
+			d.closeHTMLTag(tok.tag)
+		default:
+			d.openHTMLTag(tok)
+		}
+	}
+}
+
+// htmlText writes text from HTML, with its white space collapsed to single spaces, and dropped at the start of a line or table cell
+func (d *Document) htmlText(text string) {
+	text = htmlSpace.ReplaceAllString(text, " ")
+	if d.inCell() {
+		row := d.table.rows[len(d.table.rows)-1]
+		if written := row[len(row)-1].text; written == "" || strings.HasSuffix(written, "\n") {
+			text = strings.TrimLeft(text, " ")
+		}
+	} else if len(d.runs) == 0 && d.fpdf.GetX() <= d.leftMargin+1e-6 {
+		text = strings.TrimLeft(text, " ")
+	}
+	if text != "" {
+		d.render(&markdown.Text{Content: text})
+	}
+}
+
+// openHTMLTag applies an opening tag, drawing void elements such as images and rules, and opening the others until their end tag
+func (d *Document) openHTMLTag(tok htmlToken) {
+	switch tok.tag {
+	case "br":
+		if d.inCell() {
+			row := d.table.rows[len(d.table.rows)-1]
+			row[len(row)-1].text += "\n"
+			return
+		}
+		d.write("\n", "")
+		return
+	case "hr":
+		d.htmlBlockStart()
+		d.hr(d.theme.Hr.LineStyle)
+		return
+	case "img":
+		d.htmlImage(tok.attrs)
+		return
+	case "wbr":
+		return
+	}
+	if htmlVoid[tok.tag] {
+		d.unsupportedHTML(tok.tag)
+		return
+	}
+
+	el := htmlElement{name: tok.tag, alignment: d.alignment, href: d.html.href}
+	style := Style{}
+	switch tok.tag {
+	case "b", "strong":
+		style.FontStyle = "B"
+	case "i", "em", "cite":
+		style.FontStyle = "I"
+	case "u", "ins":
+		style.FontStyle = "U"
+	case "s", "strike", "del":
+		style.FontStyle = "S"
+	case "code", "tt", "kbd", "samp":
+		style = Style{FontFamily: d.theme.Code.FontFamily, FontSize: d.theme.Code.FontSize, Color: d.theme.Code.Color}
+	case "mark":
+		style = d.theme.Highlight
+	case "span", "thead", "tbody", "tfoot", "sup", "sub", "small", "big", "font":
+	case "a":
+		style = d.theme.Link
+		d.html.href = tok.attrs["href"]
+	case "p", "div", "center", "caption", "h1", "h2", "h3", "h4", "h5", "h6":
+		d.htmlBlockStart()
+		switch {
+		case tok.tag == "p":
+			d.space(d.spaceBefore(&markdown.ParagraphOpen{}))
+		case tok.tag[0] == 'h':
+			level := int(tok.tag[1] - '0')
+			d.space(d.spaceBefore(&markdown.HeadingOpen{HLevel: level}))
+			style = d.headingStyle(level)
+		}
+		if tok.tag == "center" || tok.tag == "caption" {
+			d.alignment = alignCenter
+		}
+		el.close = d.htmlBlockEnd(tok.tag)
+	case "blockquote":
+		d.htmlBlockStart()
+		d.render(&markdown.BlockquoteOpen{})
+		el.close = func() {
+			d.flushRuns()
+			d.render(&markdown.BlockquoteClose{})
+		}
+	case "table":
+		el.close = d.openHTMLTable(tok.attrs)
+	case "tr":
+		d.closeHTMLTo("td", "th")
+		d.closeHTMLTo("tr")
+		if !d.inHTMLTable() {
+			d.unsupportedHTML(tok.tag)
+			return
+		}
+		d.render(&markdown.TrOpen{})
+		el.close = d.htmlSpannedCells
+	case "td", "th":
+		d.closeHTMLTo("td", "th")
+		if !d.inHTMLTable() || len(d.table.rows) == 0 {
+			d.unsupportedHTML(tok.tag)
+			return
+		}
+		colSpan := d.openHTMLCell(tok)
+		el.close = func() { d.closeHTMLCell(tok.tag == "th", colSpan) }
+	default:
+		d.unsupportedHTML(tok.tag)
+		return
+	}
+
+	d.pushStyle(style)
+	switch tok.tag {
+	case "mark":
+		if c, ok := d.color(d.theme.Highlight.Background); ok {
+			d.highlight = &c
+		}
+	case "sup", "sub":
+		d.script(tok.tag == "sub")
+	case "small", "big":
+		scale := 5.0 / 6
+		if tok.tag == "big" {
+			scale = 1.2
+		}
+		d.setStyle(Style{FontSize: d.fontSize * scale})
+	case "font":
+		d.htmlFont(tok.attrs)
+	}
+	if align, ok := htmlAlignment(tok.attrs["align"]); ok && htmlBlock(tok.tag) {
+		d.alignment = align
+	}
+	d.htmlCSS(tok.attrs["style"], htmlBlock(tok.tag))
+	d.html.open = append(d.html.open, el)
+}
+
+// closeHTMLTag applies an end tag, closing the element it ends along with any elements left open inside it
+func (d *Document) closeHTMLTag(tag string) {
+	for i := len(d.html.open) - 1; i >= 0; i-- {
+		if d.html.open[i].name == tag {
+			d.closeHTML(i)
+			return
+		}
+	}
+	// end tags of unsupported elements were reported with their opening tag
+}
+
+// closeHTML closes the open HTML elements down to the number given, innermost first
+func (d *Document) closeHTML(open int) {
+	for len(d.html.open) > open {
+		l := len(d.html.open)
+		el := d.html.open[l-1]
+		d.html.open = d.html.open[:l-1]
+		if el.close != nil {
+			el.close()
+		}
+		d.popStyle()
+		d.alignment = el.alignment
+		d.html.href = el.href
+	}
+}
+
+// closeHTMLTo closes the innermost open element if it is one of the tags given, for elements whose end tag can be left out, such as table cells
+func (d *Document) closeHTMLTo(tags ...string) {
+	l := len(d.html.open)
+	if l == 0 {
+		return
+	}
+	for _, tag := range tags {
+		if d.html.open[l-1].name == tag {
+			d.closeHTML(l - 1)
+			return
+		}
+	}
+}
+
+// htmlBlock reports if an element is a block of its own, starting and ending its lines, which alignment applies to
+func htmlBlock(tag string) bool {
+	switch tag {
+	case "p", "div", "center", "caption", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote":
+		return true
+	}
+	return false
+}
+
+// inCell reports if text is being written to a table cell
+func (d *Document) inCell() bool {
+	return d.writeMode == tableHead || d.writeMode == tableCell
+}
+
+// htmlBlockStart starts a block element on a line of its own, closing an open paragraph as HTML does
+func (d *Document) htmlBlockStart() {
+	d.closeHTMLTo("p")
+	if d.inCell() {
+		row := d.table.rows[len(d.table.rows)-1]
+		if row[len(row)-1].text != "" {
+			row[len(row)-1].text += "\n"
+		}
+		return
+	}
+	d.flushRuns()
+	if d.fpdf.GetX() > d.leftMargin+1e-6 {
+		d.fpdf.Write(d.lineHeight, "\n")
+	}
+}
+
+// htmlBlockEnd gives the function ending a block element, ending its last line, and leaving the space after a paragraph or heading
+func (d *Document) htmlBlockEnd(tag string) func() {
+	return func() {
+		if d.inCell() {
+			return
+		}
+		d.flushRuns()
+		switch {
+		case tag == "p":
+			d.fpdf.Write(d.lineHeight, "\n")
+			d.space(spacing(d.theme.Paragraph.SpaceAfter, d.lineHeight))
+		case tag[0] == 'h':
+			d.fpdf.Write(d.lineHeight, "\n")
+			d.space(spacing(d.theme.Headings[tag[1]-'1'].SpaceAfter, d.lineHeight))
+		default:
+			if d.fpdf.GetX() > d.leftMargin+1e-6 {
+				d.fpdf.Write(d.lineHeight, "\n")
+			}
+		}
+		d.fpdf.SetX(d.leftMargin)
+	}
+}
+
+// inHTMLTable reports if the innermost open table element is a table, rather than the content of a cell or caption
+func (d *Document) inHTMLTable() bool {
+	for i := len(d.html.open) - 1; i >= 0; i-- {
+		switch d.html.open[i].name {
+		case "table":
+			return true
+		case "td", "th", "caption":
+			return false
+		}
+	}
+	return false
+}
+
+// openHTMLTable starts collecting the rows of an HTML table, written as a markdown table when it closes,
+// with the border and align attributes applying to this table only, and gives the function ending it
+func (d *Document) openHTMLTable(attrs map[string]string) func() {
+	d.htmlBlockStart()
+	lines, align := d.table.lines, d.table.align
+	if border, ok := attrs["border"]; ok {
+		d.table.lines = border != "0"
+	}
+	switch strings.ToLower(attrs["align"]) {
+	case "left":
+		d.table.align = rules.AlignLeft
+	case "center":
+		d.table.align = rules.AlignCenter
+	}
+	spans := d.html.spans
+	d.html.spans = nil
+	d.render(&markdown.TableOpen{})
+	return func() {
+		d.render(&markdown.TableClose{})
+		d.table.lines, d.table.align = lines, align
+		d.html.spans = spans
+	}
+}
+
+// openHTMLCell starts a table cell, after cells merged into cells spanning down from the rows above, and gives the columns it spans
+func (d *Document) openHTMLCell(tok htmlToken) int {
+	d.htmlSpannedCells()
+	head := tok.tag == "th"
+	if head {
+		d.render(&markdown.ThOpen{})
+	} else {
+		d.render(&markdown.TdOpen{})
+	}
+	// HTML cells give their spans with attributes, so their text is never a merge marker
+	row := d.table.rows[len(d.table.rows)-1]
+	row[len(row)-1].literal = true
+	colSpan, _ := strconv.Atoi(tok.attrs["colspan"])
+	rowSpan, _ := strconv.Atoi(tok.attrs["rowspan"])
+	colSpan = int(math.Max(1, float64(colSpan)))
+	if rowSpan > 1 {
+		col := len(d.table.rows[len(d.table.rows)-1]) - 1
+		for i := 0; i < colSpan; i++ {
+			for len(d.html.spans) <= col+i {
+				d.html.spans = append(d.html.spans, htmlSpan{})
+			}
+			marker := cellMergeLeft
+			if i == 0 {
+				marker = cellMergeUp
+			}
+			d.html.spans[col+i] = htmlSpan{rows: rowSpan - 1, marker: marker}
+		}
+	}
+	return colSpan
+}
+
+// closeHTMLCell ends a table cell, merging the columns it spans into it
+func (d *Document) closeHTMLCell(head bool, colSpan int) {
+	row := d.table.rows[len(d.table.rows)-1]
+	row[len(row)-1].text = strings.TrimSpace(row[len(row)-1].text)
+	d.writeMode = normal
+	for i := 1; i < colSpan; i++ {
+		d.table.rows[len(d.table.rows)-1] = append(d.table.rows[len(d.table.rows)-1], cell{text: cellMergeLeft, head: head})
+	}
+}
+
+// htmlSpannedCells adds cells merged into the cells spanning down from the rows above, for the columns they cover next in the current row
+func (d *Document) htmlSpannedCells() {
+	r := len(d.table.rows) - 1
+	for col := len(d.table.rows[r]); col < len(d.html.spans) && d.html.spans[col].rows > 0; col++ {
+		d.table.rows[r] = append(d.table.rows[r], cell{text: d.html.spans[col].marker})
+		d.html.spans[col].rows--
+	}
+}
+
+// htmlFont applies the color, face and size attributes of a font tag, its size 1 to 7, or relative to the default size of 3 with a sign
+func (d *Document) htmlFont(attrs map[string]string) {
+	style := Style{Color: cssColor(attrs["color"])}
+	if face := attrs["face"]; face != "" {
+		style.FontFamily = d.htmlFontFamily(face)
+	}
+	if size := strings.TrimSpace(attrs["size"]); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			d.unsupportedHTML("font size=" + size)
+		} else {
+			if size[0] == '+' || size[0] == '-' {
+				n += 3
+			}
+			n = int(math.Max(1, math.Min(7, float64(n))))
+			style.FontSize = htmlFontSizes[n-1]
+		}
+	}
+	d.setStyle(style)
+}
+
+// htmlFontFamily gives the core font for the first font of a list of fonts that maps onto one, falling back on generic font families
+func (d *Document) htmlFontFamily(list string) string {
+	for _, name := range strings.Split(list, ",") {
+		switch strings.ToLower(strings.Trim(strings.TrimSpace(name), `"'`)) {
+		case "courier", "courier new", "monospace":
+			return "Courier"
+		case "times", "times new roman", "serif":
+			return "Times"
+		case "arial", "helvetica", "sans-serif":
+			return "Arial"
+		}
+	}
+	d.unsupportedHTML("font " + list)
+	return ""
+}
+
+// htmlCSS applies the declarations of a style attribute the document supports, with text alignment only applying to block elements
+func (d *Document) htmlCSS(css string, block bool) {
+	for _, decl := range strings.Split(css, ";") {
+		parts := strings.SplitN(decl, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		prop := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(parts[1]), "!important"))
+		lower := strings.ToLower(value)
+		switch prop {
+		case "color":
+			d.setStyle(Style{Color: cssColor(value)})
+		case "background-color", "background":
+			if c, ok := d.color(cssColor(value)); ok {
+				d.highlight = &c
+			}
+		case "font-weight":
+			n, _ := strconv.Atoi(lower)
+			d.htmlFontStyle("B", lower == "bold" || lower == "bolder" || n >= 600)
+		case "font-style":
+			d.htmlFontStyle("I", lower == "italic" || lower == "oblique")
+		case "text-decoration", "text-decoration-line":
+			d.htmlFontStyle("U", strings.Contains(lower, "underline"))
+			d.htmlFontStyle("S", strings.Contains(lower, "line-through"))
+		case "font-size":
+			if size, ok := d.cssFontSize(lower); ok {
+				d.setStyle(Style{FontSize: size})
+			}
+		case "font-family":
+			d.setStyle(Style{FontFamily: d.htmlFontFamily(value)})
+		case "text-align":
+			if align, ok := htmlAlignment(lower); ok && block {
+				d.alignment = align
+			}
+		case "vertical-align":
+			switch lower {
+			case "super", "sub":
+				d.script(lower == "sub")
+			}
+		default:
+			d.unsupportedHTML("style " + prop)
+		}
+	}
+}
+
+// htmlFontStyle adds or removes a font style letter from the style the text is written in
+func (d *Document) htmlFontStyle(style string, on bool) {
+	d.fontStyle = strings.Replace(d.fontStyle, style, "", -1)
+	if on {
+		d.fontStyle += style
+	}
+	d.flushTextStyling()
+}
+
+// cssFontSize reads a CSS font size in points, given as a length, relative to the current size in em or %, or a size keyword
+func (d *Document) cssFontSize(value string) (float64, bool) {
+	keywords := map[string]float64{"xx-small": 7, "x-small": 7.5, "small": 10, "medium": 12, "large": 13.5, "x-large": 18, "xx-large": 24,
+		"smaller": d.fontSize * 5 / 6, "larger": d.fontSize * 1.2}
+	if size, ok := keywords[value]; ok {
+		return size, true
+	}
+	if strings.HasSuffix(value, "em") {
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(value, "em"), 64); err == nil && f > 0 {
+			return d.fontSize * f, true
+		}
+	}
+	l, err := rules.ParseLength(value)
+	if err != nil || l.Value == 0 {
+		d.unsupportedHTML("font-size " + value)
+		return 0, false
+	}
+	if l.Unit == "%" {
+		return d.fontSize * l.Value / 100, true
+	}
+	return l.Points(), true
+}
+
+// htmlAlignment reads an align attribute or text-align value
+func htmlAlignment(value string) (alignment, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "left":
+		return alignLeft, true
+	case "center":
+		return alignCenter, true
+	case "right":
+		return alignRight, true
+	case "justify":
+		return alignJustify, true
+	}
+	return alignLeft, false
+}
+
+var cssRGB = regexp.MustCompile(`^rgba?\(\s*(\d+)\s*,\s*(\d+)\s*,\s*(\d+)\s*(?:,[^)]*)?\)$`)
+
+// cssColor converts a CSS rgb() colour to the #rrggbb form used by the theme, leaving other colours as they are
+func cssColor(value string) string {
+	m := cssRGB.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	if m == nil {
+		return value
+	}
+	c := "#"
+	for _, v := range m[1:] {
+		n, _ := strconv.Atoi(v)
+		c += fmt.Sprintf("%02x", int(math.Min(255, float64(n))))
+	}
+	return c
+}
+
+// htmlImage draws an image from a file or a data URI on a line of its own, in line with the text alignment,
+// sized by its width and height in pixels, or a percentage of the line, or at its own size at 96 pixels to the inch,
+// and scaled down to fit the line, with its alt text written in its place if it can't be loaded
+func (d *Document) htmlImage(attrs map[string]string) {
+	name, info := d.registerImage(attrs["src"])
+	if info == nil {
+		if alt := attrs["alt"]; alt != "" {
+			d.write(alt, "")
+		}
+		return
+	}
+	d.htmlBlockStart()
+	wd, _ := d.fpdf.GetPageSize()
+	_, _, rm, _ := d.fpdf.GetMargins()
+	avail := wd - rm - d.leftMargin
+	info.SetDpi(96)
+	w, h := info.Width(), info.Height()
+	width, wok := d.htmlLength(attrs["width"], avail)
+	height, hok := d.htmlLength(attrs["height"], avail)
+	switch {
+	case wok && hok:
+		w, h = width, height
+	case wok:
+		w, h = width, h*width/w
+	case hok:
+		w, h = w*height/h, height
+	}
+	if w > avail {
+		w, h = avail, h*avail/w
+	}
+	if !d.pageTemplate && d.fpdf.GetY()+h > d.pageBottom() && h <= d.freshSpace() {
+		d.pageBreak()
+	}
+	x, y := d.leftMargin, d.fpdf.GetY()
+	switch d.alignment {
+	case alignCenter:
+		x += (avail - w) / 2
+	case alignRight:
+		x += avail - w
+	}
+	d.fpdf.ImageOptions(name, x, y, w, h, false, gofpdf.ImageOptions{}, 0, d.html.href)
+	d.fpdf.SetXY(d.leftMargin, y+h)
+}
+
+// registerImage loads an image from a file or a data URI into the pdf, giving the name it is registered under,
+// or nil info if it can't be loaded, reporting why
+func (d *Document) registerImage(src string) (string, *gofpdf.ImageInfoType) {
+	if info := d.fpdf.GetImageInfo(src); info != nil {
+		return src, info
+	}
+	report := func(format string, args ...interface{}) (string, *gofpdf.ImageInfoType) {
+		if !d.measuring {
+			fmt.Printf("[docgen] Error loading image: "+format+"\r\n", args...)
+		}
+		return "", nil
+	}
+	var info *gofpdf.ImageInfoType
+	switch {
+	case src == "":
+		return report("no src given")
+	case strings.HasPrefix(src, "data:"):
+		// data:image/png;base64,...
+		comma := strings.IndexByte(src, ',')
+		if comma < 0 {
+			return report("invalid data URI %.40q", src)
+		}
+		meta := src[len("data:"):comma]
+		kind := strings.TrimPrefix(strings.SplitN(meta, ";", 2)[0], "image/")
+		if !strings.HasSuffix(meta, ";base64") || !imageType(kind) {
+			return report("unsupported data URI %.40q", src)
+		}
+		data, err := base64.StdEncoding.DecodeString(src[comma+1:])
+		if err != nil {
+			return report("invalid data URI: %v", err)
+		}
+		info = d.fpdf.RegisterImageOptionsReader(src, gofpdf.ImageOptions{ImageType: kind}, bytes.NewReader(data))
+	case strings.Contains(src, "://"):
+		return report("only local files and data URIs can be used, not %v", src)
+	default:
+		if !imageType(strings.TrimPrefix(filepath.Ext(src), ".")) {
+			return report("unsupported image type %v", src)
+		}
+		if _, err := os.Stat(src); err != nil {
+			return report("%v", err)
+		}
+		info = d.fpdf.RegisterImageOptions(src, gofpdf.ImageOptions{})
+	}
+	if !d.fpdf.Ok() {
+		err := d.fpdf.Error()
+		// a broken image shouldn't stop the rest of the document being written
+		d.fpdf.ClearError()
+		return report("%v: %v", src, err)
+	}
+	return src, info
+}
+
+// imageType reports if an image type, from a file extension or MIME type, is one the pdf can embed
+func imageType(kind string) bool {
+	switch strings.ToLower(kind) {
+	case "png", "jpg", "jpeg", "gif":
+		return true
+	}
+	return false
+}
+
+// htmlLength reads an HTML length, a number of pixels, a CSS length, or a percentage of relative, reporting if it is given and valid
+func (d *Document) htmlLength(value string, relative float64) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	l, err := rules.ParseLength(value)
+	if err != nil || l.Value == 0 {
+		d.unsupportedHTML("length " + value)
+		return 0, false
+	}
+	if l.Unit == "" {
+		l.Unit = "px"
+	}
+	return d.length(l, relative), true
+}
+
+// unsupportedHTML reports an HTML tag, attribute value or style the document can't write, which is left out
+func (d *Document) unsupportedHTML(what string) {
+	if !d.measuring {
+		fmt.Printf("[docgen] Unsupported HTML: %v\r\n", what)
+	}
+}
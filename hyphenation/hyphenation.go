@@ -0,0 +1,205 @@
+// Package hyphenation finds where words can be hyphenated, using Frank Liang's pattern based algorithm as used by TeX
+package hyphenation
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Hyphenator finds hyphenation points in words using a set of patterns, and a list of exception words
+type Hyphenator struct {
+	patterns   map[string][]uint8 // pattern values by the letters of the pattern, with . marking the start or end of a word
+	exceptions map[string][]int   // hyphenation points of words the patterns don't hyphenate correctly
+	maxLen     int                // length of the longest pattern, in letters
+
+	// LeftMin and RightMin are the fewest letters to leave before the first hyphen and after the last
+	LeftMin  int
+	RightMin int
+}
+
+var english struct {
+	once sync.Once
+	h    *Hyphenator
+}
+
+// English gives a Hyphenator for US English, using the patterns of TeX,
+// each call giving a new Hyphenator whose LeftMin and RightMin can be changed, sharing the patterns which are only read
+func English() *Hyphenator {
+	english.once.Do(func() {
+		h, err := New(englishPatterns)
+		if err != nil {
+			panic(fmt.Sprintf("hyphenation: invalid english patterns: %v", err))
+		}
+		english.h = h
+	})
+	h := *english.h
+	return &h
+}
+
+/*New builds a Hyphenator from patterns in the format of the TeX hyphenation pattern files (hyph-*.tex), for adding other languages
+ * patterns are separated by whitespace, and can be wrapped in \patterns{...}, with exception words, hyphenated with -, in \hyphenation{...}
+ * anything after a % on a line is a comment
+ * the default LeftMin and RightMin of 2 and 3 suit English, and can be changed on the result for other languages
+ */
+func New(patterns string) (*Hyphenator, error) {
+	h := &Hyphenator{
+		patterns:   map[string][]uint8{},
+		exceptions: map[string][]int{},
+		LeftMin:    2,
+		RightMin:   3,
+	}
+
+	var src strings.Builder
+	for _, line := range strings.Split(patterns, "\n") {
+		if i := strings.IndexByte(line, '%'); i >= 0 {
+			line = line[:i]
+		}
+		src.WriteString(line)
+		src.WriteString("\n")
+	}
+	text := src.String()
+
+	exceptions := ""
+	if start, end, ok := section(text, `\hyphenation`); ok {
+		exceptions = text[start:end]
+		text = text[:start] + text[end:]
+	}
+	if start, end, ok := section(text, `\patterns`); ok {
+		text = text[start:end]
+	}
+	text = strings.NewReplacer(`\patterns{`, " ", `\hyphenation{`, " ", "}", " ").Replace(text)
+
+	for _, pat := range strings.Fields(text) {
+		letters := []rune{}
+		values := []uint8{0}
+		for _, r := range pat {
+			if r >= '0' && r <= '9' {
+				values[len(values)-1] = uint8(r - '0')
+				continue
+			}
+			if unicode.IsDigit(r) || unicode.IsSpace(r) || r == '{' || r == '\\' {
+				return nil, fmt.Errorf("invalid pattern %q", pat)
+			}
+			letters = append(letters, unicode.ToLower(r))
+			values = append(values, 0)
+		}
+		if len(letters) == 0 {
+			return nil, fmt.Errorf("invalid pattern %q", pat)
+		}
+		h.patterns[string(letters)] = values
+		if len(letters) > h.maxLen {
+			h.maxLen = len(letters)
+		}
+	}
+
+	for _, word := range strings.Fields(exceptions) {
+		letters := []rune{}
+		points := []int{}
+		for _, r := range word {
+			if r == '-' {
+				points = append(points, len(letters))
+				continue
+			}
+			letters = append(letters, unicode.ToLower(r))
+		}
+		h.exceptions[string(letters)] = points
+	}
+	return h, nil
+}
+
+// section finds the contents of the braces following command in text
+func section(text, command string) (start, end int, ok bool) {
+	i := strings.Index(text, command)
+	if i < 0 {
+		return 0, 0, false
+	}
+	open := strings.IndexByte(text[i:], '{')
+	if open < 0 {
+		return 0, 0, false
+	}
+	start = i + open + 1
+	close := strings.IndexByte(text[start:], '}')
+	if close < 0 {
+		return start, len(text), true
+	}
+	return start, start + close, true
+}
+
+// Hyphenate gives the byte offsets in word where it can be hyphenated, each letter of a word is hyphenated separately from any punctuation around it
+func (h *Hyphenator) Hyphenate(word string) []int {
+	var points []int
+	runes := []rune(word)
+	offsets := make([]int, 0, len(runes)+1)
+	for i := range word {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(word))
+
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && unicode.IsLetter(runes[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			for _, p := range h.hyphenateLetters(runes[start:i]) {
+				points = append(points, offsets[start+p])
+			}
+			start = -1
+		}
+	}
+	return points
+}
+
+// hyphenateLetters gives the positions in a word made up only of letters, before which it can be hyphenated
+func (h *Hyphenator) hyphenateLetters(word []rune) []int {
+	n := len(word)
+	if n < h.LeftMin+h.RightMin {
+		return nil
+	}
+	lower := make([]rune, n+2)
+	lower[0] = '.'
+	lower[n+1] = '.'
+	for i, r := range word {
+		lower[i+1] = unicode.ToLower(r)
+	}
+
+	var points []int
+	allowed := func(p int) bool {
+		return p >= h.LeftMin && p <= n-h.RightMin
+	}
+	if exception, ok := h.exceptions[string(lower[1:n+1])]; ok {
+		for _, p := range exception {
+			if allowed(p) {
+				points = append(points, p)
+			}
+		}
+		return points
+	}
+
+	// values[i] is the value between lower[i-1] and lower[i], with odd values marking a hyphenation point
+	values := make([]uint8, len(lower)+1)
+	for i := range lower {
+		for j := i + 1; j <= len(lower) && j-i <= h.maxLen; j++ {
+			pat, ok := h.patterns[string(lower[i:j])]
+			if !ok {
+				continue
+			}
+			for k, v := range pat {
+				if v > values[i+k] {
+					values[i+k] = v
+				}
+			}
+		}
+	}
+	for p := 1; p < n; p++ {
+		if values[p+1]%2 == 1 && allowed(p) {
+			points = append(points, p)
+		}
+	}
+	return points
+}
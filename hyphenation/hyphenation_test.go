@@ -0,0 +1,55 @@
+package hyphenation
+
+import (
+	"strings"
+	"testing"
+)
+
+// hyphenated marks the hyphenation points of word with -
+func hyphenated(h *Hyphenator, word string) string {
+	var b strings.Builder
+	last := 0
+	for _, p := range h.Hyphenate(word) {
+		b.WriteString(word[last:p])
+		b.WriteString("-")
+		last = p
+	}
+	b.WriteString(word[last:])
+	return b.String()
+}
+
+func TestEnglish(t *testing.T) {
+	h := English()
+	for _, want := range []string{"hy-phen-a-tion", "al-go-rithm", "ta-ble", "pag-i-na-tion", "jus-ti-fi-ca-tion",
+		"com-puter", "acad-e-mies", "as-so-ciate", "Type-set-ting", "(al-go-rithm),", "cat"} {
+		word := strings.Replace(want, "-", "", -1)
+		if got := hyphenated(h, word); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	// each document can change its own copy
+	h.LeftMin = 5
+	if English().LeftMin != 2 {
+		t.Errorf("expected changing one English hyphenator to leave the others, got LeftMin %v", English().LeftMin)
+	}
+}
+
+func TestNew(t *testing.T) {
+	h, err := New(`% a made up language
+\patterns{ 1b a1 }
+\hyphenation{ ab-ab }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.LeftMin, h.RightMin = 1, 1
+	for word, want := range map[string]string{"aabba": "a-a-b-ba", "abab": "ab-ab"} {
+		if got := hyphenated(h, word); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	if _, err := New(`\patterns{ a1 \b }`); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,404 @@
+package docgen
+
+import (
+	"math"
+	"strings"
+
+	"github.com/Maldris/commonmarkDocgen/rules"
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// textRun is a piece of text held back to be laid out with the rest of its lines, with the styling it was written in
+type textRun struct {
+	text     string
+	family   string
+	style    string
+	size     float64
+	link     string
+	color    rgb
+	fill     *rgb    // highlight behind the text
+	rise     float64 // shift of the baseline for superscript and subscript
+	hidden   bool    // text written at a size of 0, kept whole as it takes no space on the line
+	footnote string  // label of the footnote referenced by the text, placed on the page the text is written on
+}
+
+// lineItem is a word, space or line break in held text
+type lineItem struct {
+	text   string
+	run    int // index of the run the item came from
+	width  float64
+	space  bool // a space between words, stretched when justifying
+	hyphen bool // a point a word can be hyphenated, only printed at the end of a line
+	tab    bool // a tab, its width depends on where it falls on the line, and text holds its leader
+	brk    bool // explicit line break
+}
+
+// holdsText reports if a token only adds to the text being held, rather than needing it written out first
+func holdsText(tok markdown.Token) bool {
+	switch tok.(type) {
+	case *markdown.Inline, *markdown.Text, *markdown.Softbreak, *markdown.Hardbreak, *markdown.CodeInline,
+		*markdown.EmphasisOpen, *markdown.EmphasisClose, *markdown.StrongOpen, *markdown.StrongClose,
+		*markdown.StrikethroughOpen, *markdown.StrikethroughClose, *markdown.LinkOpen, *markdown.LinkClose,
+		*rules.TableReference, *rules.OpenHideText, *rules.ColorOpen, *rules.ColorClose, *rules.ScriptOpen, *rules.ScriptClose,
+		*rules.FootnoteReference, *markdown.HTMLInline:
+		return true
+	}
+	return false
+}
+
+// holding reports if text is held to be laid out a line at a time, as it is aligned, hyphenated, highlighted or raised,
+// or follows text that is already held
+func (d *Document) holding() bool {
+	return d.alignment != alignLeft || d.hyphenator != nil || d.highlight != nil || d.rise != 0 || len(d.runs) > 0
+}
+
+// write prints text in flowing mode, or holds it to be laid out with the rest of its lines when aligned, hyphenated, tabbed, highlighted or raised
+func (d *Document) write(text, link string) {
+	if d.holding() || strings.Contains(text, "\t") {
+		d.runs = append(d.runs, textRun{text: text, family: d.fontFamily, style: d.fontStyle, size: d.fontSize, link: link, color: d.textColor, fill: d.highlight, rise: d.rise})
+		return
+	}
+	if link != "" {
+		d.fpdf.WriteLinkString(d.lineHeight, text, link)
+		return
+	}
+	d.fpdf.Write(d.lineHeight, text)
+}
+
+// flushRuns writes out the held text a line at a time, so each line is aligned as a whole whatever styles and links it contains
+// when justifying, the words of each wrapped line are spread across the full width, except for the last line and lines ended by a line break
+func (d *Document) flushRuns() {
+	runs := d.runs
+	d.runs = nil
+	if len(runs) == 0 {
+		return
+	}
+	items := d.lineItems(runs)
+	pageW, _ := d.fpdf.GetPageSize()
+	cm := d.fpdf.GetCellMargin()
+	fr, fg, fb := d.fpdf.GetFillColor()
+
+	for start := 0; start < len(items); {
+		lm, _, rm, _ := d.fpdf.GetMargins()
+		x := d.fpdf.GetX()
+		avail := pageW - rm - x - 2*cm
+		tabbed := d.tabWidths(items, runs, start, x)
+		end, next, wrapped := fitLine(items, start, avail, x <= lm)
+		if end == start && next == start && x <= lm {
+			if d.splitItem(&items, runs, start, avail) {
+				continue
+			}
+			// a single character wider than the line is left to overflow it
+			end, next, wrapped = start+1, start+1, start+1 < len(items)
+		}
+
+		line := items[start:end]
+		for len(line) > 0 && line[len(line)-1].space {
+			line = line[:len(line)-1]
+		}
+		width := 0.0
+		spaces := 0
+		for k, it := range line {
+			if it.hyphen && k < len(line)-1 {
+				continue
+			}
+			width += it.width
+			if it.space {
+				spaces++
+			}
+		}
+		extra := 0.0
+		alignment := d.alignment
+		if tabbed {
+			// tab stops are fixed positions on the line, so moving or spacing out the text would misplace them
+			alignment = alignLeft
+		}
+		switch alignment {
+		case alignCenter:
+			// centred on the full line, unless text earlier in the line is in the way
+			d.fpdf.SetX(math.Max(x, lm+(pageW-rm-lm-2*cm-width)/2))
+		case alignRight:
+			d.fpdf.SetX(x + math.Max(0, avail-width))
+		case alignJustify:
+			if wrapped && spaces > 0 && width < avail {
+				extra = (avail - width) / float64(spaces)
+			}
+		}
+
+		for k := 0; k < len(line); k++ {
+			it := line[k]
+			if it.space {
+				if fill := runs[it.run].fill; fill != nil {
+					d.fpdf.SetFillColor(fill.r, fill.g, fill.b)
+					d.fpdf.Rect(d.fpdf.GetX(), d.fpdf.GetY(), it.width+extra, d.lineHeight, "F")
+				}
+				d.fpdf.SetX(d.fpdf.GetX() + it.width + extra)
+				continue
+			}
+			run := runs[it.run]
+			d.fpdf.SetFont(run.family, run.style, run.size)
+			d.fpdf.SetTextColor(run.color.r, run.color.g, run.color.b)
+			if it.tab {
+				if it.text == "" {
+					d.fpdf.SetX(d.fpdf.GetX() + it.width)
+					continue
+				}
+				leader := strings.Repeat(it.text, int(it.width/d.fpdf.GetStringWidth(it.text)))
+				d.fpdf.CellFormat(it.width, d.lineHeight, leader, "", 0, "R", false, 0, "")
+				continue
+			}
+			if run.hidden {
+				x := d.fpdf.GetX()
+				d.fpdf.CellFormat(0, d.lineHeight, it.text, "", 0, "", false, 0, "")
+				d.fpdf.SetX(x)
+				continue
+			}
+			// the rest of the word in the same run is written with it, leaving out hyphens that aren't at the end of the line
+			text, width := "", 0.0
+			for ; k < len(line); k++ {
+				if line[k].run != it.run || line[k].space || line[k].tab {
+					k--
+					break
+				}
+				if line[k].hyphen && k < len(line)-1 {
+					continue
+				}
+				text += line[k].text
+				width += line[k].width
+			}
+			if run.fill != nil {
+				d.fpdf.SetFillColor(run.fill.r, run.fill.g, run.fill.b)
+				d.fpdf.Rect(d.fpdf.GetX(), d.fpdf.GetY(), width, d.lineHeight, "F")
+			}
+			// the cell is moved by the rise, so the text is shifted from the baseline of the rest of the line
+			x, y := d.fpdf.GetXY()
+			d.fpdf.SetXY(x, y-run.rise)
+			d.fpdf.CellFormat(width, d.lineHeight, text, "", 0, "", false, 0, run.link)
+			d.fpdf.SetXY(x+width, y)
+			if run.footnote != "" {
+				d.placeFootnote(run.footnote)
+			}
+		}
+		if next < len(items) || items[len(items)-1].brk {
+			d.fpdf.Ln(d.lineHeight)
+		}
+		if wrapped {
+			// spaces at a wrap are dropped rather than starting the next line
+			for next < len(items) && items[next].space {
+				next++
+			}
+		}
+		start = next
+	}
+	d.fpdf.SetFillColor(fr, fg, fb)
+	d.flushTextStyling()
+}
+
+// wrapLines splits text into lines width wide in the current font, as gofpdf's SplitLines, but hyphenating words if the document has a hyphenator
+func (d *Document) wrapLines(text string, width float64) []string {
+	if d.hyphenator == nil {
+		var lines []string
+		for _, line := range d.fpdf.SplitLines([]byte(text), width) {
+			lines = append(lines, string(line))
+		}
+		return lines
+	}
+
+	runs := []textRun{{text: text, family: d.fontFamily, style: d.fontStyle, size: d.fontSize, color: d.textColor}}
+	items := d.lineItems(runs)
+	avail := width - 2*d.fpdf.GetCellMargin()
+	var lines []string
+	for start := 0; start < len(items); {
+		end, next, wrapped := fitLine(items, start, avail, true)
+		if end == start && next == start {
+			if d.splitItem(&items, runs, start, avail) {
+				continue
+			}
+			end, next, wrapped = start+1, start+1, start+1 < len(items)
+		}
+		line := ""
+		for k, it := range items[start:end] {
+			if !it.hyphen || start+k == end-1 {
+				line += it.text
+			}
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+		if wrapped {
+			for next < len(items) && items[next].space {
+				next++
+			}
+		}
+		start = next
+	}
+	return lines
+}
+
+// lineItems splits held text into words, spaces and line breaks, measured in the font of their run, with words split where they can be hyphenated
+func (d *Document) lineItems(runs []textRun) []lineItem {
+	var items []lineItem
+	for r, run := range runs {
+		if run.hidden {
+			items = append(items, lineItem{run: r, text: run.text})
+			continue
+		}
+		d.fpdf.SetFont(run.family, run.style, run.size)
+		for l, line := range strings.Split(run.text, "\n") {
+			if l > 0 {
+				items = append(items, lineItem{run: r, brk: true})
+			}
+			for w, word := range strings.Split(line, " ") {
+				if w > 0 {
+					items = append(items, lineItem{run: r, text: " ", width: d.fpdf.GetStringWidth(" "), space: true})
+				}
+				for strings.Contains(word, "\t") {
+					i := strings.Index(word, "\t")
+					if i > 0 {
+						items = append(items, lineItem{run: r, text: word[:i], width: d.fpdf.GetStringWidth(word[:i])})
+					}
+					items = append(items, lineItem{run: r, tab: true})
+					word = word[i+1:]
+				}
+				if word == "" {
+					continue
+				}
+				last := 0
+				if d.hyphenator != nil {
+					for _, p := range d.hyphenator.Hyphenate(word) {
+						items = append(items,
+							lineItem{run: r, text: word[last:p], width: d.fpdf.GetStringWidth(word[last:p])},
+							lineItem{run: r, text: "-", width: d.fpdf.GetStringWidth("-"), hyphen: true})
+						last = p
+					}
+				}
+				items = append(items, lineItem{run: r, text: word[last:], width: d.fpdf.GetStringWidth(word[last:])})
+			}
+		}
+	}
+	d.flushTextStyling()
+	return items
+}
+
+// tabWidths sets the width of each tab in the line starting at items[start], written from x, to reach the tab stop that follows it, reporting if the line has any tabs
+// tabs past the last stop set, or all tabs if none are set, move to the next multiple of the nominal indent
+func (d *Document) tabWidths(items []lineItem, runs []textRun, start int, x float64) bool {
+	pageW, _ := d.fpdf.GetPageSize()
+	lm, _, rm, _ := d.fpdf.GetMargins()
+	width := pageW - lm - rm - 2*d.fpdf.GetCellMargin()
+	pos := x - lm
+	tabbed := false
+	for i := start; i < len(items) && !items[i].brk; i++ {
+		it := &items[i]
+		if !it.tab {
+			if !it.hyphen {
+				pos += it.width
+			}
+			continue
+		}
+		tabbed = true
+
+		stop := rules.TabStop{Position: rules.Length{Value: (math.Floor(pos/d.sizes.NominalIndent+1e-6) + 1) * d.sizes.NominalIndent}}
+		for _, s := range d.tabs {
+			if d.length(s.Position, width) > pos+1e-6 {
+				stop = s
+				break
+			}
+		}
+		target := d.length(stop.Position, width)
+		switch stop.Align {
+		case rules.TabRight:
+			target -= d.tabText(items[i+1:], runs, "")
+		case rules.TabDecimal:
+			target -= d.tabText(items[i+1:], runs, ".")
+		}
+		it.width = math.Max(0, target-pos)
+		it.text = stop.Leader
+		pos += it.width
+	}
+	return tabbed
+}
+
+// tabText measures the text following a tab, up to the next tab or line break, or to the first occurrence of point if it isn't empty
+func (d *Document) tabText(items []lineItem, runs []textRun, point string) float64 {
+	width := 0.0
+	for _, it := range items {
+		if it.tab || it.brk {
+			break
+		}
+		if it.hyphen {
+			continue
+		}
+		if i := strings.Index(it.text, point); point != "" && i >= 0 {
+			run := runs[it.run]
+			d.fpdf.SetFont(run.family, run.style, run.size)
+			width += d.fpdf.GetStringWidth(it.text[:i])
+			break
+		}
+		width += it.width
+	}
+	return width
+}
+
+// splitItem breaks a word too long to fit on a line after the last character that fits, as gofpdf does, reporting if it could be split
+func (d *Document) splitItem(items *[]lineItem, runs []textRun, i int, avail float64) bool {
+	it := (*items)[i]
+	run := runs[it.run]
+	d.fpdf.SetFont(run.family, run.style, run.size)
+	defer d.flushTextStyling()
+
+	chars := []rune(it.text)
+	n := 1
+	for n < len(chars) && d.fpdf.GetStringWidth(string(chars[:n+1])) <= avail {
+		n++
+	}
+	if n >= len(chars) {
+		return false
+	}
+	head, tail := it, it
+	head.text = string(chars[:n])
+	head.width = d.fpdf.GetStringWidth(head.text)
+	tail.text = string(chars[n:])
+	tail.width = d.fpdf.GetStringWidth(tail.text)
+	*items = append((*items)[:i], append([]lineItem{head, tail}, (*items)[i+1:]...)...)
+	return true
+}
+
+// fitLine finds the items from start that fit in a line avail wide, returning the end of the line, where the next line starts, and if the line was wrapped rather than ended by a line break or the end of the text
+// lines are broken at the last space, or hyphenation point, that fits
+// a line that doesn't start at the margin is left empty if its first word doesn't fit, so the word moves down to a full line, as gofpdf does,
+// and a line at the margin too narrow for its first word is also returned empty, for the word to be split
+func fitLine(items []lineItem, start int, avail float64, atMargin bool) (end, next int, wrapped bool) {
+	width := 0.0
+	word := false
+	brk := -1
+	for i := start; i < len(items); i++ {
+		it := items[i]
+		switch {
+		case it.brk:
+			return i, i + 1, false
+		case it.space:
+			if word {
+				brk = i
+			}
+		case it.hyphen:
+			// hyphens only take up space at the end of a line
+			if width+it.width <= avail {
+				brk = i
+			}
+			continue
+		case width+it.width > avail:
+			switch {
+			case brk >= 0 && items[brk].hyphen:
+				return brk + 1, brk + 1, true
+			case brk >= 0:
+				return brk, brk + 1, true
+			case i > start && atMargin:
+				return i, i, true
+			}
+			return start, start, true
+		default:
+			word = true
+		}
+		width += it.width
+	}
+	return len(items), len(items), false
+}
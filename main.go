@@ -3,10 +3,12 @@ package docgen
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"strings"
 	"text/template"
 
 	"github.com/Maldris/commonmarkDocgen/functions"
+	"github.com/Maldris/commonmarkDocgen/hyphenation"
 	"github.com/Maldris/commonmarkDocgen/rules"
 	"github.com/jung-kurt/gofpdf"
 	"gitlab.com/golang-commonmark/markdown"
@@ -31,21 +33,51 @@ type Document struct {
 	fpdf   *gofpdf.Fpdf
 
 	fontFamily string
-	fontSize   int
+	fontSize   float64
 	fontStyle  string
 
-	lineHeight float64
-	leftMargin float64
-	writeMode  writeMode
-	alignment  alignment
+	lineHeight    float64
+	leftMargin    float64
+	writeMode     writeMode
+	alignment     alignment
+	baseAlignment alignment // alignment text returns to at the end of a justify rule
+	runs          []textRun // text held while justifying, until its lines can be laid out
+	hyphenator    *hyphenation.Hyphenator
+	tabs          []rules.TabStop // tab stops set by the last tabs rule, the default stops are used if empty
+	tildeTabs     bool            // ~ in text is written as a tab
+	theme         Theme
+	styles        []textStyle // styles of the elements currently open, to return to as each closes
+	textColor     rgb
+	highlight     *rgb    // background of highlighted text, nil when not highlighting
+	rise          float64 // distance superscript text is raised above the baseline, or subscript lowered if negative
+	footnotes     struct {
+		endnotes bool
+		count    int               // footnotes numbered so far
+		labels   map[string]int    // footnote numbers by label
+		text     map[string]string // footnote markdown by label
+		notes    []footnote        // referenced footnotes in number order, written at the end of the document as endnotes
+		placed   map[string]bool   // footnotes already given a place on a page
+		pending  []footnote        // footnotes to write at the bottom of the current page
+		height   float64           // space reserved at the bottom of the current page for the pending footnotes
+		carried  []footnote        // footnotes that didn't fit on the page they were referenced on, to write on the next page
+	}
 
 	table struct {
-		lines  bool
-		size   rules.SizeMode
-		cols   []float64
-		colsum float64
-		align  rules.AlignMode
-		rows   [][]cell
+		lines    bool
+		size     rules.SizeMode
+		width    rules.Length
+		cols     []float64
+		colUnits []string
+		colsum   float64
+		align    rules.AlignMode
+		padH     float64
+		padV     float64
+		lineGap  float64 // extra height of each line of text in a cell, the cell margin unless the padding is set
+		valign   rules.VAlignMode
+		rows     [][]cell
+		caption  *rules.TableCaption // caption waiting to be printed above the next table
+		count    int                 // tables captioned so far, used for numbering
+		labels   map[string]int      // table numbers by caption label
 	}
 	link struct {
 		ref  string
@@ -55,8 +87,41 @@ type Document struct {
 	listStyle  listStyle
 	listStart  int
 	indents    []float64
+	termIndent float64 // distance from the left margin the definitions of the current definition list hang at
+	boxes      []*box  // boxes open around the content being written, innermost last
+	quotes     []int   // number of boxes open outside each blockquote being written, innermost last
+	boxHeight  float64 // height measured for the next box to open, to shade it ahead of its content
+	html       struct {
+		open  []htmlElement // HTML elements open around the content being written, innermost last
+		href  string        // link target of text written inside an HTML anchor
+		spans []htmlSpan    // cells of an HTML table spanning down into the rows below, by column
+	}
 	extensions template.FuncMap
 	sizes      SizesConfig
+	margins    Margins // page margins, the bottom margin excluding the space reserved for the page footer
+	page       struct {
+		orientation string
+		size        gofpdf.SizeType // portrait page size, used for page breaks
+	}
+	unit         string // unit of measurement used by the pdf
+	pageTemplate bool   // rendering a page header or footer, where content can't be moved to another page
+	measuring    bool   // rendering into a scratch pdf to measure content, where problems are left to be reported by the real render
+	earlyBreak   struct {
+		set    bool
+		bottom float64 // page break margin to restore once the break is reached
+	}
+	columns struct {
+		count     int     // number of columns, less than 2 when not in a multi-column region
+		index     int     // column currently being written to
+		width     float64 // width of each column
+		gutter    float64 // space between columns
+		left      float64 // left margin of the region, the left of the first column
+		right     float64 // right margin of the region
+		bottom    float64 // page bottom margin, restored for the last column and when the region ends
+		top       float64 // top of the columns on the current page
+		maxY      float64 // lowest point reached by the columns on the current page
+		remaining float64 // estimated height of the region content still to be placed
+	}
 
 	Debug bool
 }
@@ -67,11 +132,27 @@ const (
 	alignLeft alignment = iota
 	alignCenter
 	alignRight
+	alignJustify
 )
 
 type cell struct {
-	text string
-	head bool
+	text    string
+	head    bool
+	colSpan int  // number of columns the cell covers, 0 if merged into a neighbour
+	rowSpan int  // number of rows the cell covers, 0 if merged into a neighbour
+	literal bool // the text is written as it is, even if it is a merge marker, i.e. an escaped \<
+}
+
+// table cell contents that merge a cell into its neighbour
+const (
+	cellMergeLeft = "<"
+	cellMergeUp   = "^"
+)
+
+// isMergeMarker reports if the contents of a cell are a merge marker
+func isMergeMarker(text string) bool {
+	text = strings.TrimSpace(text)
+	return text == cellMergeLeft || text == cellMergeUp
 }
 
 type writeMode uint
@@ -99,15 +180,127 @@ const (
 /*PdfConfig A utility config object used to provide the base page size details when initialising the pdf
  * Fields:
  * 	Portrait: Flag identifying if the page layout should be portrait, if false, it will be landscape
- * 	Metric:   Flag identifying if the units of measurement used should be in metric (thus mm), if false, inches are used
- * 	Paper:    String representing the size of paper to use, options are: "A3", "A4", "A5", "Letter", or "Legal"
+ * 	Metric:   Flag identifying if the units of measurement used should be in metric (thus mm), if false, inches are used, ignored if Unit is set
+ * 	Unit:     the unit of measurement to use, one of "mm", "cm", "in" or "pt", if empty Metric decides between mm and inches
+ * 	Paper:    String representing the size of paper to use, options are: "A1" to "A6", "Letter", "Legal" or "Tabloid", if empty (and no Width and Height are given) "A4" is used
+ * 	Width:    custom page width, used with Height in place of Paper, the orientation is taken from the page dimensions rather than Portrait
+ * 	Height:   custom page height
+ * 	Margins:  page margins, if nil the default of 1cm, with a 2cm bottom margin, is used
+ * 	Justify:  Flag identifying if text should be fully justified by default, rather than left aligned
+ * 	TildeTabs: Flag identifying if ~ in text should be treated as a tab, for editors that can't enter tab characters
+ * 	Theme:    style sheet of fonts and colours for each kind of markdown element, if nil, or for any styles it leaves empty, the default styles are used
+ * 	Hyphenation: hyphenator used to break long words across lines in paragraphs and table cells, such as hyphenation.English(), if nil words aren't hyphenated
+ * 	Endnotes: Flag identifying if footnotes should be written together at the end of the document, rather than at the bottom of the page they are referenced on
  *  Sizes:    a SizesConfig object expressing which sizes to use for indents and fonts, any left as 0 will revert to the default values
+ * All lengths are in the document unit
  */
 type PdfConfig struct {
 	Portrait bool
 	Metric   bool
+	Unit     string
 	Paper    string
+	Width    float64
+	Height   float64
+	Margins  *Margins
+	Justify  bool
 	Sizes    SizesConfig
+
+	Theme       *Theme
+	TildeTabs   bool
+	Hyphenation *hyphenation.Hyphenator
+	Endnotes    bool
+}
+
+// Margins expresses the space to leave blank around the content of each page, the bottom margin is below any page footer
+type Margins struct {
+	Top    float64
+	Left   float64
+	Right  float64
+	Bottom float64
+}
+
+// paper sizes in points, portrait
+var paperSizes = map[string][2]float64{
+	"a1":      {1683.78, 2383.94},
+	"a2":      {1190.55, 1683.78},
+	"a3":      {841.89, 1190.55},
+	"a4":      {595.28, 841.89},
+	"a5":      {420.94, 595.28},
+	"a6":      {297.64, 420.94},
+	"letter":  {612, 792},
+	"legal":   {612, 1008},
+	"tabloid": {792, 1224},
+}
+
+// Validate checks the page size and margins describe a usable page, returning an error describing the first problem found
+func (c *PdfConfig) Validate() error {
+	_, _, _, err := c.page()
+	return err
+}
+
+// unit gives the unit of measurement for the document
+func (c *PdfConfig) unit() string {
+	switch {
+	case c.Unit != "":
+		return c.Unit
+	case c.Metric:
+		return "mm"
+	}
+	return "in"
+}
+
+// page works out the page orientation and portrait width and height in document units, and validates the margins fit on it
+func (c *PdfConfig) page() (orientation string, wd, ht float64, err error) {
+	unit := rules.Length{Value: 1, Unit: c.unit()}
+	if !unit.Absolute() {
+		return "", 0, 0, fmt.Errorf("unknown unit %q", c.Unit)
+	}
+	k := unit.Points()
+	orientation = "L"
+	if c.Portrait {
+		orientation = "P"
+	}
+	switch {
+	case c.Width < 0 || c.Height < 0:
+		return "", 0, 0, fmt.Errorf("invalid page size %vx%v: must not be negative", c.Width, c.Height)
+	case c.Width > 0 && c.Height > 0:
+		if c.Paper != "" {
+			return "", 0, 0, fmt.Errorf("invalid page size: paper %q and a custom width and height can't both be given", c.Paper)
+		}
+		wd, ht = c.Width, c.Height
+		orientation = "P"
+		if wd > ht {
+			orientation = "L"
+			wd, ht = ht, wd
+		}
+	case c.Width > 0 || c.Height > 0:
+		return "", 0, 0, fmt.Errorf("invalid page size %vx%v: both width and height are needed", c.Width, c.Height)
+	default:
+		paper := c.Paper
+		if paper == "" {
+			paper = "A4"
+		}
+		size, ok := paperSizes[strings.ToLower(paper)]
+		if !ok {
+			return "", 0, 0, fmt.Errorf("unknown paper size %q", c.Paper)
+		}
+		wd, ht = size[0]/k, size[1]/k
+	}
+
+	if c.Margins != nil {
+		m := c.Margins
+		if m.Top < 0 || m.Left < 0 || m.Right < 0 || m.Bottom < 0 {
+			return "", 0, 0, fmt.Errorf("invalid margins %+v: must not be negative", *m)
+		}
+		pgWd, pgHt := wd, ht
+		if orientation == "L" {
+			pgWd, pgHt = ht, wd
+		}
+		if m.Left+m.Right >= pgWd || m.Top+m.Bottom >= pgHt {
+			return "", 0, 0, fmt.Errorf("invalid margins %+v: no space left on a %vx%v page", *m, pgWd, pgHt)
+		}
+	}
+	return orientation, wd, ht, nil
 }
 
 /*SizesConfig A utility config object used to express the desired size and spacing used in the pdf
@@ -121,7 +314,12 @@ type PdfConfig struct {
  *   Heading4FontSize:  heading level 4 fond size
  *   Heading5FontSize:  heading level 5 fond size
  *   Heading6FontSize:  heading level 6 fond size
- *   CellMargin:        top and bottom cell margin for table cells
+ *   CellMargin:        extra height given to each line of text in table cells, unless a table sets its own padding
+ *   CellPadding:       horizontal padding either side of the text in table cells
+ *   ColumnGutter:      space between columns in a multi-column region
+ *   LineSpacing:       height of each line as a multiple of its font size
+ *   FootnoteFontSize:  footnote and endnote font size
+ * Font sizes are in points, and can be fractional, i.e. 10.5
  */
 type SizesConfig struct {
 	NominalIndent    float64
@@ -134,6 +332,10 @@ type SizesConfig struct {
 	Heading5FontSize float64
 	Heading6FontSize float64
 	CellMargin       float64
+	CellPadding      float64
+	ColumnGutter     float64
+	LineSpacing      float64
+	FootnoteFontSize float64
 }
 
 /*NewDocument Creates a new document object that represents an instance of document generation
@@ -149,11 +351,13 @@ func NewDocument(name, templateStr string, conf *PdfConfig) *Document {
 			Paper:    "A4",
 		}
 	}
+	// points per document unit, to convert the default lengths
+	k := rules.Length{Value: 1, Unit: conf.unit()}.Points()
 	if conf.Sizes.NominalIndent == 0 {
-		conf.Sizes.NominalIndent = nominalIndent
+		conf.Sizes.NominalIndent = nominalIndent / k
 	}
 	if conf.Sizes.BulletIndent == 0 {
-		conf.Sizes.BulletIndent = bulletIndent
+		conf.Sizes.BulletIndent = bulletIndent / k
 	}
 	if conf.Sizes.NominalFontSize == 0 {
 		conf.Sizes.NominalFontSize = nominalFontSize
@@ -177,7 +381,19 @@ func NewDocument(name, templateStr string, conf *PdfConfig) *Document {
 		conf.Sizes.Heading6FontSize = heading6FontSize
 	}
 	if conf.Sizes.CellMargin == 0 {
-		conf.Sizes.CellMargin = cellMargin
+		conf.Sizes.CellMargin = cellMargin / k
+	}
+	if conf.Sizes.CellPadding == 0 {
+		conf.Sizes.CellPadding = cellPadding / k
+	}
+	if conf.Sizes.ColumnGutter == 0 {
+		conf.Sizes.ColumnGutter = columnGutter / k
+	}
+	if conf.Sizes.LineSpacing == 0 {
+		conf.Sizes.LineSpacing = lineSpacing
+	}
+	if conf.Sizes.FootnoteFontSize == 0 {
+		conf.Sizes.FootnoteFontSize = footnoteFontSize
 	}
 
 	t := &template.Template{}
@@ -188,29 +404,61 @@ func NewDocument(name, templateStr string, conf *PdfConfig) *Document {
 		template: templateStr,
 		parser:   markdown.New(),
 		// fpdf:       pdf,
-		fontSize:   nominalFontSize,
+		fontSize:   conf.Sizes.NominalFontSize,
 		fontStyle:  "",
 		fontFamily: "Arial",
-		lineHeight: 5,
+		lineHeight: conf.Sizes.NominalFontSize * conf.Sizes.LineSpacing / k,
 		// leftMargin: leftMargin,
 		sizes: conf.Sizes,
 	}
+	doc.theme = conf.Theme.merged(defaultTheme)
 	doc.pdfInit(conf)
+	doc.setStyle(doc.theme.Paragraph)
 	// pdf generator doesnt support typographic fancy quotes, overwriting the fancy ones to normal ones
 	doc.table.lines = true
 	doc.table.size = rules.SizeWrap
 	doc.table.cols = []float64{}
+	doc.table.colUnits = []string{}
 	doc.table.colsum = 0
 	doc.table.align = rules.AlignCenter
+	doc.table.padH = doc.sizes.CellPadding
+	doc.table.lineGap = doc.sizes.CellMargin
+	doc.table.valign = rules.VAlignTop
+	doc.table.labels = map[string]int{}
+	if conf.Justify {
+		doc.alignment = alignJustify
+		doc.baseAlignment = alignJustify
+	}
+	doc.hyphenator = conf.Hyphenation
+	doc.footnotes.endnotes = conf.Endnotes
+	doc.footnotes.labels = map[string]int{}
+	doc.footnotes.text = map[string]string{}
+	doc.footnotes.placed = map[string]bool{}
+	doc.tildeTabs = conf.TildeTabs
 	doc.parser.Typographer = false
 	doc.parser.Linkify = false
 	doc.parser.Quotes = [4]string{"\"", "\"", "'", "'"}
 	doc.parser.HTML = true
 	markdown.RegisterBlockRule(1050, rules.RulePageBreak, nil)
 	markdown.RegisterBlockRule(1055, rules.RuleTableSettings, nil)
+	markdown.RegisterBlockRule(1060, rules.RuleTableCaption, nil)
+	markdown.RegisterBlockRule(1065, rules.RuleColumns, nil)
+	markdown.RegisterBlockRule(1070, rules.RuleKeep, nil)
+	markdown.RegisterBlockRule(1075, rules.RuleTabs, nil)
+	markdown.RegisterBlockRule(1080, rules.RuleDefinitionList, nil)
+	markdown.RegisterBlockRule(1085, rules.RuleBox, []int{1100})
+	markdown.RegisterBlockRule(1090, rules.RuleHr, []int{1100})
+	markdown.RegisterBlockRule(650, rules.RuleFootnote, []int{1100, 700})
+	markdown.RegisterCoreRule(150, rules.RuleTaskList)
+	markdown.RegisterCoreRule(160, rules.RuleCallout)
 	markdown.RegisterInlineRule(2000, rules.RuleHangIndent)
 	markdown.RegisterInlineRule(2200, rules.RuleJustify)
 	markdown.RegisterInlineRule(200, rules.RuleHideText)
+	markdown.RegisterInlineRule(250, rules.RuleTableReference)
+	markdown.RegisterInlineRule(2300, rules.RuleColor)
+	markdown.RegisterInlineRule(2400, rules.RuleHighlight)
+	markdown.RegisterInlineRule(2500, rules.RuleScript)
+	markdown.RegisterInlineRule(650, rules.RuleFootnoteReference)
 	return doc
 }
 
@@ -225,24 +473,38 @@ func (d *Document) AddExtensionFunctions(funcs map[string]interface{}) {
 }
 
 func (d *Document) pdfInit(conf *PdfConfig) {
-	orientation := "L"
-	if conf.Portrait {
-		orientation = "P"
-	}
-	units := "mm"
-	if !conf.Metric {
-		units = "in"
+	units := conf.unit()
+	orientation, wd, ht, err := conf.page()
+	if err != nil {
+		// fall back to a default page so the document is still usable, with the error reported when it is executed or rendered
+		units = "mm"
+		orientation, wd, ht, _ = (&PdfConfig{Portrait: true, Metric: true}).page()
+	}
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		OrientationStr: orientation,
+		UnitStr:        units,
+		Size:           gofpdf.SizeType{Wd: wd, Ht: ht},
+	})
+	d.fpdf = pdf
+	d.unit = units
+	d.page.orientation = orientation
+	d.page.size = gofpdf.SizeType{Wd: wd, Ht: ht}
+	if err != nil {
+		pdf.SetError(err)
 	}
-	if conf.Paper != "A3" && conf.Paper != "A4" && conf.Paper != "A5" && conf.Paper != "Letter" && conf.Paper != "Legal" {
-		conf.Paper = "A4"
+	if conf.Margins != nil && err == nil {
+		pdf.SetMargins(conf.Margins.Left, conf.Margins.Top, conf.Margins.Right)
+		pdf.SetAutoPageBreak(true, conf.Margins.Bottom)
 	}
-	pdf := gofpdf.New(orientation, units, conf.Paper, "")
-	// pdf := gofpdf.New("P", "mm", "A4", "")
-	d.fpdf = pdf
-	leftMargin, _, _, _ := pdf.GetMargins()
-	d.leftMargin = leftMargin
+	lm, tm, rm, bm := pdf.GetMargins()
+	d.margins = Margins{Top: tm, Left: lm, Right: rm, Bottom: bm}
+	d.leftMargin = lm
+	pdf.SetAcceptPageBreakFunc(d.acceptPageBreak)
+	pdf.SetFooterFunc(d.footer)
 	pdf.AddPage()
-	pdf.SetFont(d.fontFamily, d.fontStyle, float64(d.fontSize))
+	// the page header starts from the second page
+	pdf.SetHeaderFunc(d.header)
+	pdf.SetFont(d.fontFamily, d.fontStyle, d.fontSize)
 }
 
 // SetDocumentHeader is used to provide the template to use to generate the document header, a template only used once at the start of the document, but for which the page header still occurs
@@ -253,62 +515,104 @@ func (d *Document) SetDocumentHeader(template string) {
 // SetPageHeader is used to provide a template that will be used to build a header section for each page in the pdf, except the first page
 func (d *Document) SetPageHeader(template string) {
 	d.subTemplates.pageHeader = template
-	if template == "" {
-		d.fpdf.SetHeaderFunc(func() {})
-		return
-	}
-	d.fpdf.SetHeaderFunc(func() {
+}
+
+// header is called by gofpdf at the start of each page after the first, to write the page header and continue any boxes broken by the page break
+func (d *Document) header() {
+	if d.subTemplates.pageHeader != "" {
 		d.params["_page"] = d.fpdf.PageNo()
+		d.pageTemplate = true
+		// the header can be written in the middle of a table, which is carried on once the header's own tables are done
+		table := d.table
 		err := d.renderTemplate("_pageHeader", d.subTemplates.pageHeader)
+		d.table = table
+		d.pageTemplate = false
 		if err != nil {
 			d.fpdf.SetError(err)
 			return
 		}
 		d.fpdf.Write(d.lineHeight, "\n\n")
-	})
+	}
+	if d.columns.count < 2 {
+		// in a multi-column region, boxes continue once the columns are set up on the page
+		d.resumeBoxes()
+	}
 }
 
 // SetPageFooter is used to provide a template that will be used to build a footer section for each page in the pdf
 func (d *Document) SetPageFooter(template string) {
 	d.subTemplates.pageFooter = template
-	if template == "" {
-		d.fpdf.SetFooterFunc(func() {})
-		return
-	}
-	d.fpdf.SetFooterFunc(func() {
+}
+
+// footer is called by gofpdf at the end of each page, to write the footnotes placed on the page and the page footer
+func (d *Document) footer() {
+	finalMarkdown := ""
+	if d.subTemplates.pageFooter != "" {
 		d.params["_page"] = d.fpdf.PageNo()
-		finalMarkdown, err := templateSubstitution(d.t, "_footer", d.subTemplates.pageFooter, d.params, d.extensions)
+		var err error
+		finalMarkdown, err = templateSubstitution(d.t, "_footer", d.subTemplates.pageFooter, d.params, d.extensions)
 		if err != nil {
 			d.fpdf.SetError(err)
 			return
 		}
-		strWd := d.fpdf.GetStringWidth(finalMarkdown)
-		pgWd, pgHt := d.fpdf.GetPageSize()
-		footHeight := strWd / pgWd
-		_, _, _, bMarg := d.fpdf.GetMargins()
-		if d.fpdf.GetY() <= pgHt-(bMarg+2*d.lineHeight+footHeight) {
-			d.fpdf.SetY(pgHt - (bMarg + 2*d.lineHeight + footHeight))
-			d.fpdf.Write(d.lineHeight, "\n\n")
-		}
-		tokens := d.parser.Parse([]byte(finalMarkdown))
-		for _, tok := range tokens {
-			d.render(tok)
-		}
-	})
+	}
+	d.breakBoxes()
+	d.pageTemplate = true
+	table := d.table
+	defer func() {
+		d.pageTemplate = false
+		d.table = table
+	}()
+	// the footer sits directly above the bottom margin, in the space reserved for it by reserveFooter, with the footnotes above it
+	_, pgHt := d.fpdf.GetPageSize()
+	top := pgHt - d.margins.Bottom
+	if finalMarkdown != "" {
+		top -= d.footerHeight(finalMarkdown)
+	}
+	d.writeFootnotes(top)
+	if finalMarkdown == "" {
+		return
+	}
+	if d.fpdf.GetY() < top {
+		d.fpdf.SetY(top)
+	}
+	d.fpdf.SetX(d.leftMargin)
+	d.fpdf.Write(d.lineHeight, "\n\n")
+	d.renderTokens(d.parser.Parse([]byte(finalMarkdown)))
 }
 
-func (d *Document) generateDocumentHeader() error {
-	if d.subTemplates.docHeader == "" {
+// reserveFooter moves the page break up from the bottom margin to leave space for the page footer
+func (d *Document) reserveFooter() error {
+	if d.subTemplates.pageFooter == "" {
 		return nil
 	}
-	err := d.renderTemplate("_header", d.subTemplates.docHeader)
+	d.params["_page"] = d.fpdf.PageNo()
+	finalMarkdown, err := templateSubstitution(d.t, "_footer", d.subTemplates.pageFooter, d.params, d.extensions)
 	if err != nil {
 		return err
 	}
-	d.fpdf.Write(d.lineHeight, "\n\n")
+	d.fpdf.SetAutoPageBreak(true, d.margins.Bottom+d.footerHeight(finalMarkdown))
 	return nil
 }
 
+// footerHeight estimates the height of the rendered page footer, including the blank lines separating it from the page content
+func (d *Document) footerHeight(footer string) float64 {
+	pgWd, _ := d.fpdf.GetPageSize()
+	lines := 2.0
+	for _, line := range strings.Split(strings.TrimRight(footer, "\n"), "\n") {
+		lines += math.Max(1, math.Ceil(d.fpdf.GetStringWidth(line)/(pgWd-(d.margins.Left+d.margins.Right))))
+	}
+	return lines * d.lineHeight
+}
+
+func (d *Document) generateDocumentHeader(tokens []markdown.Token) {
+	if d.subTemplates.docHeader == "" {
+		return
+	}
+	d.renderTokens(tokens)
+	d.fpdf.Write(d.lineHeight, "\n\n")
+}
+
 // RegisterSubTemplate will register a new template with name and body, this new template can then be invoked inside the documents template
 func (d *Document) RegisterSubTemplate(name, body string) {
 	temp := struct {
@@ -323,17 +627,39 @@ func (d *Document) RegisterSubTemplate(name, body string) {
 
 // Execute takes in the parameters to use to generate the document, and does the template parse, and document generation, effectively executing all templates loaded into the document
 func (d *Document) Execute(data map[string]interface{}) error {
+	if !d.fpdf.Ok() {
+		return d.fpdf.Error()
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
 	d.params = data
 	err := d.parseSubtemplates()
 	if err != nil {
 		return err
 	}
-	err = d.generateDocumentHeader()
+	err = d.reserveFooter()
 	if err != nil {
 		return err
 	}
-	err = d.renderTemplate(d.name, d.template)
-	return err
+	var header []markdown.Token
+	if d.subTemplates.docHeader != "" {
+		header, err = d.parseTemplate("_header", d.subTemplates.docHeader)
+		if err != nil {
+			return err
+		}
+	}
+	body, err := d.parseTemplate(d.name, d.template)
+	if err != nil {
+		return err
+	}
+	// tables are numbered through the whole document before it is written, so tables can be referenced before they appear
+	d.numberTables(header)
+	d.numberTables(body)
+	d.generateDocumentHeader(header)
+	d.renderTokens(body)
+	d.finishFootnotes()
+	return nil
 }
 
 func (d *Document) parseSubtemplates() error {
@@ -372,15 +698,104 @@ func (d *Document) RenderToString() (string, error) {
 }
 
 func (d *Document) renderTemplate(name, temp string) error {
-	finalMarkdown, err := templateSubstitution(d.t, name, temp, d.params, d.extensions)
+	tokens, err := d.parseTemplate(name, temp)
 	if err != nil {
 		return err
 	}
-	tokens := d.parser.Parse([]byte(finalMarkdown))
-	for _, tok := range tokens {
+	d.renderTokens(tokens)
+	return nil
+}
+
+// parseTemplate executes the template temp with the document params, and parses the markdown it gives
+func (d *Document) parseTemplate(name, temp string) ([]markdown.Token, error) {
+	finalMarkdown, err := templateSubstitution(d.t, name, temp, d.params, d.extensions)
+	if err != nil {
+		return nil, err
+	}
+	return d.parser.Parse([]byte(finalMarkdown)), nil
+}
+
+func (d *Document) renderTokens(tokens []markdown.Token) {
+	d.numberFootnotes(tokens)
+	columns := false
+	boxes := len(d.boxes)
+	html := len(d.html.open)
+	for i, tok := range tokens {
+		switch tk := tok.(type) {
+		case *rules.TableCaption:
+			if !tk.Below && !tableNext(tokens, i) {
+				fmt.Printf("[docgen] Table caption without a table after it: %v\r\n", tk.Text)
+				continue
+			}
+		case *rules.Columns:
+			// column regions run to the next columns directive, or the end of the template
+			d.columnsStart(tk, columnRegion(tokens[i+1:]))
+			columns = true
+			continue
+		case *rules.BoxOpen, *markdown.BlockquoteOpen:
+			// shaded boxes are measured, to shade them ahead of their content
+			if style, ok := d.boxStyle(tok); ok && style.Background != "" {
+				end := i + blockEnd(tokens[i:]) + 1
+				if end > len(tokens) {
+					end = len(tokens)
+				}
+				d.boxHeight = d.measureBlock(tokens[i:end])
+			}
+			d.paginate(tokens[i:])
+		case *rules.KeepOpen, *markdown.HeadingOpen, *markdown.ParagraphOpen, *rules.DefinitionTermOpen:
+			d.paginate(tokens[i:])
+		}
 		d.render(tok)
 	}
-	return nil
+	d.closeHTML(html)
+	d.flushRuns()
+	for len(d.boxes) > boxes && !d.boxes[len(d.boxes)-1].bar {
+		// boxes without an \endbox run to the end of the template
+		d.closeCallout()
+	}
+	if columns {
+		d.columnsEnd()
+	}
+	if d.table.caption != nil {
+		fmt.Printf("[docgen] Table caption without a table after it: %v\r\n", d.table.caption.Text)
+		d.table.caption = nil
+	}
+}
+
+// numberTables assigns each table caption in the document its number, and places it below its table if it directly follows one,
+// captions above a table only being numbered if the table is directly after them
+func (d *Document) numberTables(tokens []markdown.Token) {
+	for i, tok := range tokens {
+		caption, ok := tok.(*rules.TableCaption)
+		if !ok {
+			continue
+		}
+		if i > 0 {
+			_, caption.Below = tokens[i-1].(*markdown.TableClose)
+		}
+		if !caption.Below && !tableNext(tokens, i) {
+			continue
+		}
+		d.table.count++
+		caption.Number = d.table.count
+		if caption.Label != "" {
+			d.table.labels[caption.Label] = caption.Number
+		}
+	}
+}
+
+// tableNext reports if the token after tokens[i] starts a table, in markdown or HTML
+func tableNext(tokens []markdown.Token, i int) bool {
+	if i+1 >= len(tokens) {
+		return false
+	}
+	switch tk := tokens[i+1].(type) {
+	case *markdown.TableOpen:
+		return true
+	case *markdown.HTMLBlock:
+		return strings.Contains(strings.ToLower(tk.Content), "<table")
+	}
+	return false
 }
 
 func templateSubstitution(t *template.Template, name, tmp string, data interface{}, exts template.FuncMap) (string, error) {
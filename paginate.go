@@ -0,0 +1,200 @@
+package docgen
+
+import (
+	"math"
+
+	"github.com/Maldris/commonmarkDocgen/rules"
+	"github.com/jung-kurt/gofpdf"
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+// minLines is the fewest lines of a paragraph left on their own at the bottom or top of a page, and the lines of content kept with a heading
+const minLines = 2
+
+// paginate moves to the next page or column ahead of the first of tokens, to keep headings with the content that follows them,
+// keep blocks together, and avoid leaving lines of a paragraph stranded on their own
+func (d *Document) paginate(tokens []markdown.Token) {
+	if d.pageTemplate {
+		// page headers and footers can't move to another page
+		return
+	}
+	switch tokens[0].(type) {
+	case *rules.KeepOpen:
+		d.keep(d.measureBlock(tokens[1:blockEnd(tokens)]))
+	case *markdown.HeadingOpen:
+		end := blockEnd(tokens)
+		height := d.spaceBefore(tokens[0]) + d.measureBlock(tokens[:end+1])
+		if end+1 < len(tokens) {
+			height += minLines * d.lineHeight
+		}
+		d.keep(height)
+	case *markdown.ParagraphOpen:
+		d.avoidStrandedLines(tokens[:blockEnd(tokens)])
+	case *rules.BoxOpen:
+		// the top of a box is kept with its title and the first lines of its content
+		height := 2*d.sizes.CellMargin + minLines*d.lineHeight
+		if tokens[0].(*rules.BoxOpen).Kind != "" {
+			height += d.lineHeight
+		}
+		d.keep(height)
+	case *rules.DefinitionTermOpen:
+		// a term is kept with the start of its definition
+		d.keep(minLines * d.lineHeight)
+	}
+}
+
+// blockEnd finds the index of the token closing the block opened by the first of tokens, or the end of the tokens if it isn't closed
+func blockEnd(tokens []markdown.Token) int {
+	depth := 0
+	for i, tok := range tokens {
+		switch tok.(type) {
+		case *rules.KeepOpen, *markdown.HeadingOpen, *markdown.ParagraphOpen, *rules.BoxOpen, *markdown.BlockquoteOpen:
+			depth++
+		case *rules.KeepClose, *markdown.HeadingClose, *markdown.ParagraphClose, *rules.BoxClose, *markdown.BlockquoteClose:
+			depth--
+		}
+		if depth == 0 {
+			return i
+		}
+	}
+	return len(tokens)
+}
+
+// keep moves to the next page or column if content of height doesn't fit in the space left, as long as it will fit once moved
+func (d *Document) keep(height float64) {
+	if d.fpdf.GetY()+height > d.pageBottom() && height <= d.freshSpace() {
+		d.pageBreak()
+	}
+}
+
+// avoidStrandedLines moves a paragraph that would be split across a page break so at least minLines of it are left on each page,
+// by breaking before the paragraph, or setting an early break to move more of its lines to the next page
+func (d *Document) avoidStrandedLines(tokens []markdown.Token) {
+	y := d.fpdf.GetY() + d.spaceBefore(tokens[0])
+	bottom := d.pageBottom()
+	if y+d.lineHeight > bottom {
+		// gofpdf will break before the first line anyway
+		return
+	}
+	height := d.measureBlock(tokens)
+	if y+height <= bottom {
+		return
+	}
+	lines := int(math.Round(height / d.lineHeight))
+	fit := int(math.Floor((bottom-y)/d.lineHeight + 1e-6))
+	keep := fit
+	if lines-keep < minLines {
+		keep = lines - minLines
+	}
+	switch {
+	case keep >= fit:
+	case keep < minLines:
+		d.keep(height)
+	default:
+		_, pageh := d.fpdf.GetPageSize()
+		_, bottom := d.fpdf.GetAutoPageBreak()
+		d.earlyBreak.bottom = bottom
+		d.earlyBreak.set = true
+		d.fpdf.SetAutoPageBreak(true, pageh-(y+float64(keep)*d.lineHeight+d.lineHeight/100))
+	}
+}
+
+// restoreBreak clears an early page break set to move lines of a paragraph to the next page
+func (d *Document) restoreBreak() {
+	if d.earlyBreak.set {
+		d.fpdf.SetAutoPageBreak(true, d.earlyBreak.bottom)
+		d.earlyBreak.set = false
+	}
+}
+
+// freshSpace gives the height available at the top of the next page or column
+func (d *Document) freshSpace() float64 {
+	if d.columns.count > 1 && d.columns.index < d.columns.count-1 {
+		return d.pageBottom() - d.columns.top
+	}
+	_, pageh := d.fpdf.GetPageSize()
+	_, top, _, _ := d.fpdf.GetMargins()
+	_, bottom := d.fpdf.GetAutoPageBreak()
+	if d.columns.count > 1 {
+		bottom = d.columns.bottom
+	}
+	return pageh - bottom - top
+}
+
+// measureBlock measures the height of tokens written from the current position within the current margins
+func (d *Document) measureBlock(tokens []markdown.Token) float64 {
+	wd, _ := d.fpdf.GetPageSize()
+	lm, _, rm, _ := d.fpdf.GetMargins()
+	return d.measure(tokens, wd-(lm+rm), d.fpdf.GetX()-lm)
+}
+
+// measure renders tokens into a scratch pdf width wide, starting x across the first line, to find the height they take up
+func (d *Document) measure(tokens []markdown.Token, width, x float64) float64 {
+	pdf := d.scratchPdf(width)
+	m := d.subDocument(pdf)
+	m.measuring = true
+	m.page.orientation = "P"
+	m.page.size.Wd, m.page.size.Ht = pdf.GetPageSize()
+	m.fontFamily = d.fontFamily
+	m.fontSize = d.fontSize
+	m.fontStyle = d.fontStyle
+	m.lineHeight = d.lineHeight
+	m.alignment = d.alignment
+	m.listStyle = d.listStyle
+	m.listStart = d.listStart
+	m.textColor = d.textColor
+	m.flushTextStyling()
+	pdf.SetX(x)
+	for _, tok := range tokens {
+		m.render(tok)
+	}
+	m.flushRuns()
+	height := pdf.GetY()
+	if pdf.GetX() > 0 {
+		// count the unfinished last line
+		height += m.lineHeight
+	}
+	return height
+}
+
+// scratchPdf creates a pdf width wide and without margins or page breaks, for content to be rendered into to measure it
+func (d *Document) scratchPdf(width float64) *gofpdf.Fpdf {
+	_, pageh := d.fpdf.GetPageSize()
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		OrientationStr: "P",
+		UnitStr:        d.unit,
+		Size:           gofpdf.SizeType{Wd: width, Ht: pageh * 100},
+	})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.SetCellMargin(d.fpdf.GetCellMargin())
+	pdf.AddPage()
+	return pdf
+}
+
+// subDocument creates a document writing to pdf with the settings of the document, but none of its state part way through the content,
+// for rendering content outside the flow of the document
+func (d *Document) subDocument(pdf *gofpdf.Fpdf) *Document {
+	m := &Document{
+		parser:        d.parser,
+		params:        d.params,
+		extensions:    d.extensions,
+		fpdf:          pdf,
+		fontFamily:    d.fontFamily,
+		fontSize:      d.sizes.NominalFontSize,
+		lineHeight:    d.sizes.NominalFontSize * d.sizes.LineSpacing / pdf.GetConversionRatio(),
+		baseAlignment: d.baseAlignment,
+		alignment:     d.baseAlignment,
+		sizes:         d.sizes,
+		unit:          d.unit,
+		measuring:     d.measuring,
+		hyphenator:    d.hyphenator,
+		tabs:          d.tabs,
+		tildeTabs:     d.tildeTabs,
+		theme:         d.theme,
+		textColor:     d.textColor,
+	}
+	m.table = d.table
+	m.page = d.page
+	return m
+}
@@ -4,15 +4,23 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/Maldris/commonmarkDocgen/rules"
+	"github.com/jung-kurt/gofpdf"
 	"gitlab.com/golang-commonmark/markdown"
 )
 
+const mm = 72 / 25.4 // points per millimetre
+
+// default sizes, font sizes are in points, and lengths in points converted to the document unit when used
 const (
-	nominalIndent    = 10
-	bulletIndent     = 5
+	nominalIndent    = 10 * mm
+	bulletIndent     = 5 * mm
+	lineHeight       = 5 * mm                       // line height at the nominal font size
+	lineSpacing      = lineHeight / nominalFontSize // line height as a multiple of the font size
 	nominalFontSize  = 12
 	heading1FontSize = 24
 	heading2FontSize = 22
@@ -20,21 +28,60 @@ const (
 	heading4FontSize = 18
 	heading5FontSize = 16
 	heading6FontSize = 14
-	cellMargin       = 2 // marge top/bottom of cell
+	cellMargin       = 2 * mm // marge top/bottom of cell
+	cellPadding      = 1 * mm // marge left/right of cell
+	columnGutter     = 5 * mm // space between columns
+	footnoteFontSize = 9
+)
+
+// superscript and subscript text sizes and shifts, as fractions of the font size of the text around them
+const (
+	scriptScale     = 0.65
+	superscriptRise = 0.35
+	subscriptDrop   = 0.15
+)
+
+// size of a task list checkbox, as a fraction of the font size of its item
+const checkboxSize = 0.6
+
+// lengths of the dashes of a dashed horizontal rule and the gaps between them, in points
+const (
+	dashLength = 2 * mm
+	dashGap    = 1 * mm
 )
 
 func (d *Document) render(tok markdown.Token) {
 	if d.Debug {
 		fmt.Printf("[docgen] [%v] %v :: %v # %v\r\n", tok.Tag(), reflect.TypeOf(tok), tok.Block(), tok)
 	}
+	if len(d.runs) > 0 && !holdsText(tok) {
+		d.flushRuns()
+	}
 	switch tok.(type) {
 	case *markdown.BlockquoteOpen:
+		d.quotes = append(d.quotes, len(d.boxes))
+		if style, ok := d.boxStyle(tok); ok {
+			d.openBox(style, true, 0)
+		}
 		d.leftMargin += d.sizes.NominalIndent
 		d.fpdf.SetLeftMargin(d.leftMargin)
+		d.pushStyle(d.theme.Blockquote)
 	case *markdown.BlockquoteClose:
+		boxes := 0
+		if l := len(d.quotes); l > 0 {
+			boxes = d.quotes[l-1]
+			d.quotes = d.quotes[:l-1]
+		}
+		for len(d.boxes) > boxes && !d.boxes[len(d.boxes)-1].bar {
+			// callouts without an \endbox end with the quote they are in
+			d.closeCallout()
+		}
 		d.leftMargin -= d.sizes.NominalIndent
 		d.fpdf.SetLeftMargin(d.leftMargin)
-		d.flushTextStyling()
+		d.popStyle()
+		if len(d.boxes) > boxes {
+			d.closeBox()
+		}
 		d.fpdf.SetLeftMargin(d.leftMargin)
 		// d.fpdf.Write(d.lineHeight, "\n")
 		d.fpdf.SetX(d.leftMargin)
@@ -69,15 +116,19 @@ func (d *Document) render(tok markdown.Token) {
 		d.fpdf.SetLeftMargin(d.leftMargin)
 		d.fpdf.SetX(d.leftMargin)
 
-	case *markdown.ListItemOpen:
-		switch d.listStyle {
-		case dash:
-			d.fpdf.Write(d.lineHeight, "-")
-		case carat:
-			d.fpdf.Write(d.lineHeight, ">")
-		case numberedDot:
-			tk := tok.(*markdown.ListItemOpen)
-			d.fpdf.Writef(d.lineHeight, "%v.", (tk.Map[0]-d.listStart)+1)
+	case *markdown.ListItemOpen, *rules.TaskItemOpen:
+		if tk, ok := tok.(*rules.TaskItemOpen); ok {
+			d.checkbox(tk.Checked)
+		} else {
+			switch d.listStyle {
+			case dash:
+				d.fpdf.Write(d.lineHeight, "-")
+			case carat:
+				d.fpdf.Write(d.lineHeight, ">")
+			case numberedDot:
+				tk := tok.(*markdown.ListItemOpen)
+				d.fpdf.Writef(d.lineHeight, "%v.", (tk.Map[0]-d.listStart)+1)
+			}
 		}
 		d.leftMargin += d.sizes.BulletIndent
 		d.fpdf.SetLeftMargin(d.leftMargin)
@@ -92,13 +143,16 @@ func (d *Document) render(tok markdown.Token) {
 
 	case *markdown.CodeBlock:
 		tk := tok.(*markdown.CodeBlock)
-		d.codeBlock(strings.Replace(tk.Content, "\n    ", "\n", -1))
+		d.codeBlock(strings.Replace(tk.Content, "\n    ", "\n", -1), "", false)
 	case *markdown.CodeInline: // TODO
 		ci := tok.(*markdown.CodeInline)
-		d.fpdf.Write(d.lineHeight, ci.Content)
+		d.pushStyle(d.theme.Code)
+		d.write(ci.Content, "")
+		d.popStyle()
 	case *markdown.Fence:
 		tk := tok.(*markdown.Fence)
-		d.codeBlock(tk.Content)
+		lang, numbered := fenceInfo(tk.Params)
+		d.codeBlock(tk.Content, lang, numbered)
 
 	case *markdown.EmphasisOpen:
 		d.applyStyle("I")
@@ -117,73 +171,65 @@ func (d *Document) render(tok markdown.Token) {
 		// d.writeMode = normal
 
 	case *markdown.Softbreak:
-		d.fpdf.Write(d.lineHeight, "\n")
+		d.write("\n", "")
 	case *markdown.Hardbreak:
-		d.fpdf.Write(d.lineHeight, "\n")
+		d.write("\n", "")
 
 	case *markdown.HeadingOpen:
 		hd := tok.(*markdown.HeadingOpen)
-		switch hd.HLevel {
-		case 1:
-			d.fontSize = int(d.sizes.Heading1FontSize)
-		case 2:
-			d.fontSize = int(d.sizes.Heading2FontSize)
-		case 3:
-			d.fontSize = int(d.sizes.Heading3FontSize)
-		case 4:
-			d.fontSize = int(d.sizes.Heading4FontSize)
-		case 5:
-			d.fontSize = int(d.sizes.Heading5FontSize)
-		case 6:
-			d.fontSize = int(d.sizes.Heading6FontSize)
-		}
-		d.lineHeight *= float64(d.fontSize) / d.sizes.NominalFontSize
-		d.flushTextStyling()
+		d.space(d.spaceBefore(tok))
+		d.pushStyle(d.headingStyle(hd.HLevel))
 	case *markdown.HeadingClose:
-		d.lineHeight /= float64(d.fontSize) / d.sizes.NominalFontSize
-		d.fontSize = int(d.sizes.NominalFontSize)
-		d.flushTextStyling()
-		d.fpdf.Write(d.lineHeight, "\n\n")
+		hd := tok.(*markdown.HeadingClose)
+		d.popStyle()
+		d.fpdf.Write(d.lineHeight, "\n")
+		d.space(spacing(d.theme.Headings[hd.HLevel-1].SpaceAfter, d.lineHeight))
 
 	case *markdown.HTMLBlock:
-		tk := tok.(*markdown.HTMLBlock)
-		html := d.fpdf.HTMLBasicNew()
-		html.Write(d.lineHeight, tk.Content)
-		d.fpdf.SetX(d.leftMargin)
+		d.renderHTML(tok.(*markdown.HTMLBlock).Content)
 	case *markdown.HTMLInline:
-		tk := tok.(*markdown.HTMLInline)
-		html := d.fpdf.HTMLBasicNew()
-		html.Write(d.lineHeight, tk.Content)
+		d.renderHTML(tok.(*markdown.HTMLInline).Content)
 
 	case *markdown.Hr:
-		wd, _ := d.fpdf.GetPageSize()
-		d.fpdf.Line(d.leftMargin, d.fpdf.GetY(), wd-d.leftMargin, d.fpdf.GetY())
-		d.fpdf.Write(d.lineHeight, "\n")
+		d.hr(d.theme.Hr.LineStyle)
+	case *rules.Hr:
+		style := tok.(*rules.Hr).Style
+		if style == "" {
+			style = d.theme.Hr.LineStyle
+		}
+		d.hr(style)
 
 	case *markdown.Image: // TODO
 	case *markdown.Inline:
 		il := tok.(*markdown.Inline)
+		if (d.writeMode == tableHead || d.writeMode == tableCell) && !isMergeMarker(il.Content) {
+			// the text of the cell is unescaped, so only the source shows if it is a marker or an escaped < or ^
+			row := d.table.rows[len(d.table.rows)-1]
+			row[len(row)-1].literal = true
+		}
+		open := len(d.html.open)
 		for _, tok := range il.Children {
 			d.render(tok)
 		}
+		// inline HTML elements left open end with the text they are in
+		d.closeHTML(open)
 
 	case *markdown.LinkOpen:
 		ln := tok.(*markdown.LinkOpen)
 		d.writeMode = link
 		d.link.ref = ln.Href
 	case *markdown.LinkClose:
-		d.applyStyle("U")
-		if d.link.ref != "" {
-			d.fpdf.WriteLinkString(d.lineHeight, d.link.text, d.link.ref)
-		} else {
-			d.fpdf.Write(d.lineHeight, d.link.text)
-		}
-		d.removeStyle("U")
+		d.pushStyle(d.theme.Link)
+		d.write(d.link.text, d.link.ref)
+		d.popStyle()
 		d.writeMode = normal
 
 	case *markdown.ParagraphOpen:
+		d.space(d.spaceBefore(tok))
 	case *markdown.ParagraphClose:
-		d.fpdf.Write(d.lineHeight, "\n\n")
+		d.restoreBreak()
+		d.fpdf.Write(d.lineHeight, "\n")
+		d.space(spacing(d.theme.Paragraph.SpaceAfter, d.lineHeight))
 		d.fpdf.SetX(d.leftMargin)
 
 	case *markdown.TableOpen:
@@ -204,6 +250,7 @@ func (d *Document) render(tok markdown.Token) {
 
 	case *markdown.ThOpen:
 		d.writeMode = tableHead
+		d.table.rows[len(d.table.rows)-1] = append(d.table.rows[len(d.table.rows)-1], cell{head: true})
 	case *markdown.ThClose:
 		d.writeMode = normal
 
@@ -212,28 +259,24 @@ func (d *Document) render(tok markdown.Token) {
 
 	case *markdown.TdOpen:
 		d.writeMode = tableCell
+		d.table.rows[len(d.table.rows)-1] = append(d.table.rows[len(d.table.rows)-1], cell{head: false})
 	case *markdown.TdClose:
 		d.writeMode = normal
 
 	case *markdown.Text:
 		txt := tok.(*markdown.Text)
-		content := strings.Replace(txt.Content, "~", "    ", -1) // REVIEW: temp till editor buttons for tab in ui
-		content = strings.Replace(content, "\t", "    ", -1)
+		content := txt.Content
+		if d.tildeTabs {
+			content = strings.Replace(content, "~", "\t", -1)
+		}
 		switch d.writeMode {
 		case normal:
-			switch d.alignment {
-			case alignLeft:
-				d.fpdf.Write(d.lineHeight, content)
-			case alignCenter:
-				d.fpdf.WriteAligned(0, d.lineHeight, content, "C")
-			case alignRight:
-				d.fpdf.WriteAligned(0, d.lineHeight, content, "R")
-			}
-		case tableHead:
-			// d.table.head = append(d.table.head, content)
-			d.table.rows[len(d.table.rows)-1] = append(d.table.rows[len(d.table.rows)-1], cell{text: strings.Replace(content, "\\n", "\n", -1), head: true})
-		case tableCell:
-			d.table.rows[len(d.table.rows)-1] = append(d.table.rows[len(d.table.rows)-1], cell{text: strings.Replace(content, "\\n", "\n", -1), head: false})
+			d.write(content, d.html.href)
+		case tableHead, tableCell:
+			// table cells don't have tab stops
+			content = strings.Replace(content, "\t", "    ", -1)
+			row := d.table.rows[len(d.table.rows)-1]
+			row[len(row)-1].text += strings.Replace(content, "\\n", "\n", -1)
 		case link:
 			d.link.text = content
 		case strikethrough:
@@ -249,12 +292,27 @@ func (d *Document) render(tok markdown.Token) {
 			d.alignment = alignCenter
 		case 2:
 			d.alignment = alignRight
+		case 3:
+			d.alignment = alignJustify
 		}
 	case *rules.JustifyClose:
-		d.alignment = alignLeft
+		d.alignment = d.baseAlignment
 
 	case *rules.PageBreak:
-		d.fpdf.AddPage()
+		tk := tok.(*rules.PageBreak)
+		if tk.Orientation != "" {
+			d.page.orientation = tk.Orientation
+		}
+		if tk.Paper != "" {
+			size, ok := paperSizes[strings.ToLower(tk.Paper)]
+			if ok {
+				k := d.fpdf.GetConversionRatio()
+				d.page.size = gofpdf.SizeType{Wd: size[0] / k, Ht: size[1] / k}
+			} else {
+				fmt.Printf("[docgen] Unknown paper size for page break: %v\r\n", tk.Paper)
+			}
+		}
+		d.addPage()
 
 	case *rules.OpenHangingIndent:
 		if d.indents == nil {
@@ -270,22 +328,270 @@ func (d *Document) render(tok markdown.Token) {
 			d.fpdf.SetLeftMargin(d.leftMargin)
 			d.indents = d.indents[:l-1]
 		} else {
-			d.fpdf.SetLeftMargin(10)
+			d.leftMargin = d.margins.Left
+			if d.columns.count > 1 {
+				d.leftMargin = d.columnLeft()
+			}
+			d.fpdf.SetLeftMargin(d.leftMargin)
+		}
+
+	case *rules.KeepOpen:
+	case *rules.KeepClose:
+
+	case *rules.DefinitionListOpen:
+		d.termIndent = d.definitionIndent(tok.(*rules.DefinitionListOpen).Terms)
+		d.fpdf.SetX(d.leftMargin)
+	case *rules.DefinitionListClose:
+		d.space(spacing(d.theme.Paragraph.SpaceAfter, d.lineHeight))
+		d.fpdf.SetX(d.leftMargin)
+	case *rules.DefinitionTermOpen:
+		d.pushStyle(Style{FontStyle: "B"})
+	case *rules.DefinitionTermClose:
+		d.popStyle()
+	case *rules.DefinitionOpen:
+		// the definition follows its term on the same line if there is room, and hangs from there
+		hang := d.leftMargin + d.termIndent
+		if d.fpdf.GetX() > hang-d.sizes.BulletIndent+1e-6 {
+			d.fpdf.Write(d.lineHeight, "\n")
 		}
+		d.fpdf.SetX(hang)
+		d.render(&rules.OpenHangingIndent{})
+	case *rules.DefinitionClose:
+		d.render(&rules.ClosenHangingIndent{})
+		d.fpdf.Write(d.lineHeight, "\n")
+		d.fpdf.SetX(d.leftMargin)
+
+	case *rules.BoxOpen:
+		d.openCallout(tok.(*rules.BoxOpen))
+	case *rules.BoxClose:
+		d.closeCallout()
+	case *rules.BoxTitleOpen:
+		// the title is written in bold, in the colour of the box's border
+		style := Style{FontStyle: "B"}
+		if l := len(d.boxes); l > 0 {
+			style.Color = d.boxes[l-1].style.BorderColor
+		}
+		d.pushStyle(style)
+	case *rules.BoxTitleClose:
+		d.popStyle()
+		d.flushRuns()
+		d.fpdf.Write(d.lineHeight, "\n")
+
+	case *rules.ColorOpen:
+		tk := tok.(*rules.ColorOpen)
+		style, highlight := Style{Color: tk.Color}, tk.Highlight
+		if tk.Mark {
+			style, highlight = d.theme.Highlight, d.theme.Highlight.Background
+		}
+		d.pushStyle(style)
+		if c, ok := d.color(highlight); ok {
+			d.highlight = &c
+		}
+	case *rules.ColorClose:
+		d.popStyle()
+
+	case *rules.ScriptOpen:
+		tk := tok.(*rules.ScriptOpen)
+		if tk.Sub && d.tildeTabs {
+			// with ~ written as tabs, subscript markers are still tabs
+			d.write("\t", "")
+			break
+		}
+		d.pushStyle(Style{})
+		d.script(tk.Sub)
+	case *rules.ScriptClose:
+		tk := tok.(*rules.ScriptClose)
+		if tk.Sub && d.tildeTabs {
+			d.write("\t", "")
+			break
+		}
+		d.popStyle()
+
+	case *rules.Footnote:
+	case *rules.FootnoteReference:
+		d.footnoteReference(tok.(*rules.FootnoteReference))
+
+	case *rules.TabStops:
+		d.tabs = tok.(*rules.TabStops).Stops
 
 	case *rules.TableHeader:
 		tk := tok.(*rules.TableHeader)
 		d.table.lines = tk.Lines
 		d.table.size = tk.Size
+		d.table.width = tk.Width
 		d.table.cols = tk.Cols
+		d.table.colUnits = tk.ColUnits
 		d.table.colsum = tk.Colsum
 		d.table.align = tk.Alignment
+		d.table.valign = tk.VAlign
+		d.table.padH = d.sizes.CellPadding
+		d.table.padV = 0
+		d.table.lineGap = d.sizes.CellMargin
+		if len(tk.Padding) > 0 {
+			d.table.padH = d.length(tk.Padding[0], 0)
+			d.table.padV = d.table.padH
+			d.table.lineGap = 0
+		}
+		if len(tk.Padding) > 1 {
+			d.table.padV = d.length(tk.Padding[1], 0)
+		}
+	case *rules.TableCaption:
+		tk := tok.(*rules.TableCaption)
+		if tk.Below {
+			d.tableCaption(tk)
+		} else {
+			d.table.caption = tk
+		}
+	case *rules.TableReference:
+		tk := tok.(*rules.TableReference)
+		d.write(d.tableReference(tk.Label), "")
 	case *rules.OpenHideText:
 		tk := tok.(*rules.OpenHideText)
+		if d.holding() {
+			d.runs = append(d.runs, textRun{text: tk.Content, family: d.fontFamily, style: d.fontStyle, hidden: true})
+			break
+		}
+		// gofpdf treats the zero width text as a cell to the end of the line, so the position is put back after it
+		x := d.fpdf.GetX()
 		d.fpdf.SetFontSize(0)
 		d.fpdf.Write(d.lineHeight, tk.Content)
-		d.fpdf.SetFontSize(float64(d.fontSize))
+		d.fpdf.SetFontSize(d.fontSize)
+		d.fpdf.SetX(x)
+
+	}
+}
+
+// headingStyle gives the style of a heading level, with its font size taken from the SizesConfig heading sizes if the theme doesn't set it
+func (d *Document) headingStyle(level int) Style {
+	style := d.theme.Headings[level-1]
+	if style.FontSize == 0 {
+		switch level {
+		case 1:
+			style.FontSize = d.sizes.Heading1FontSize
+		case 2:
+			style.FontSize = d.sizes.Heading2FontSize
+		case 3:
+			style.FontSize = d.sizes.Heading3FontSize
+		case 4:
+			style.FontSize = d.sizes.Heading4FontSize
+		case 5:
+			style.FontSize = d.sizes.Heading5FontSize
+		case 6:
+			style.FontSize = d.sizes.Heading6FontSize
+		}
+	}
+	return style
+}
+
+// script raises the text that follows as superscript, or lowers it as subscript, at a smaller size, until the style is popped
+func (d *Document) script(sub bool) {
+	// raised or lowered in proportion to the size of the text around it
+	rise := superscriptRise * d.fontSize / d.fpdf.GetConversionRatio()
+	if sub {
+		rise = -subscriptDrop * d.fontSize / d.fpdf.GetConversionRatio()
+	}
+	d.rise += rise
+	d.fontSize *= scriptScale
+	d.flushTextStyling()
+}
+
+// addPage starts a new page, using the page size and orientation set by the last page break rather than the document default
+// in a multi-column region the page header and footer are written at full width, and the new page starts in the first column
+func (d *Document) addPage() {
+	if d.columns.count < 2 {
+		d.fpdf.AddPageFormat(d.page.orientation, d.page.size)
+		return
+	}
+	// boxes are broken in the column they reach the end of the page in
+	d.breakBoxes()
+	d.shiftColumns(-float64(d.columns.index) * (d.columns.width + d.columns.gutter))
+	d.columns.index = 0
+	_, _, rm, _ := d.fpdf.GetMargins()
+	d.fpdf.SetRightMargin(d.columns.right)
+	d.fpdf.SetAutoPageBreak(true, d.columns.bottom)
+	d.fpdf.AddPageFormat(d.page.orientation, d.page.size)
+	// back to the first column once the page header and footer are written
+	d.fpdf.SetRightMargin(rm)
+	d.columnsPage()
+	d.resumeBoxes()
+}
+
+// pageBreak moves to the next column of a multi-column region, or the next page once the last column is full
+func (d *Document) pageBreak() {
+	if d.columns.count > 1 && d.columns.index < d.columns.count-1 {
+		d.nextColumn()
+		return
+	}
+	d.addPage()
+}
+
+// definitionIndent gives the distance from the left margin the definitions of a definition list hang at, clear of the widest of its terms,
+// up to a third of the line so long terms don't squeeze their definitions, with the definitions of terms too long for that starting on the next line
+func (d *Document) definitionIndent(terms []*markdown.Inline) float64 {
+	wd, _ := d.fpdf.GetPageSize()
+	_, _, rm, _ := d.fpdf.GetMargins()
+	limit := (wd - rm - d.leftMargin) / 3
+	indent := math.Min(d.sizes.NominalIndent, limit)
+	d.pushStyle(Style{FontStyle: "B"})
+	for _, term := range terms {
+		text := ""
+		for _, tok := range term.Children {
+			switch tk := tok.(type) {
+			case *markdown.Text:
+				text += tk.Content
+			case *markdown.CodeInline:
+				text += tk.Content
+			}
+		}
+		if width := d.fpdf.GetStringWidth(text) + d.sizes.BulletIndent; width <= limit {
+			indent = math.Max(indent, width)
+		}
+	}
+	d.popStyle()
+	return indent
+}
 
+// pageBottom gives the lowest point content can be written to on the current page or column
+func (d *Document) pageBottom() float64 {
+	_, pageh := d.fpdf.GetPageSize()
+	_, _, _, mbottom := d.fpdf.GetMargins()
+	return pageh - mbottom
+}
+
+// spacing gives the space to leave for a theme spacing of v, def if it isn't set, or none if it is negative
+func spacing(v, def float64) float64 {
+	switch {
+	case v < 0:
+		return 0
+	case v == 0:
+		return def
+	}
+	return v
+}
+
+// spaceBefore gives the space to leave above a paragraph or heading, none at the top of a page or column, or if it doesn't start a line
+func (d *Document) spaceBefore(tok markdown.Token) float64 {
+	v := 0.0
+	switch tk := tok.(type) {
+	case *markdown.ParagraphOpen:
+		v = spacing(d.theme.Paragraph.SpaceBefore, 0)
+	case *markdown.HeadingOpen:
+		v = spacing(d.theme.Headings[tk.HLevel-1].SpaceBefore, 0)
+	}
+	_, top, _, _ := d.fpdf.GetMargins()
+	if d.columns.count > 1 {
+		top = d.columns.top
+	}
+	if d.fpdf.GetY() <= top+1e-6 || d.fpdf.GetX() > d.leftMargin+1e-6 {
+		return 0
+	}
+	return v
+}
+
+// space moves down by height, to the left margin
+func (d *Document) space(height float64) {
+	if height > 0 {
+		d.fpdf.SetY(d.fpdf.GetY() + height)
 	}
 }
 
@@ -302,86 +608,381 @@ func (d *Document) removeStyle(style string) {
 }
 
 func (d *Document) flushTextStyling() {
-	d.fpdf.SetFont(d.fontFamily, d.fontStyle, float64(d.fontSize))
+	d.fpdf.SetFont(d.fontFamily, d.fontStyle, d.fontSize)
+	d.fpdf.SetTextColor(d.textColor.r, d.textColor.g, d.textColor.b)
 }
 
-func (d *Document) codeBlock(content string) {
-	wpage, _ := d.fpdf.GetPageSize()
-	lmarge, _, rmarge, _ := d.fpdf.GetMargins()
-	r, g, b := d.fpdf.GetFillColor()
-	d.fpdf.SetFillColor(180, 180, 180)
-	d.leftMargin += d.sizes.NominalIndent
-	d.fpdf.SetLeftMargin(d.leftMargin)
-	d.fpdf.MultiCell(wpage-(lmarge+rmarge)-20, d.lineHeight, content, "", "", true)
-	d.leftMargin -= d.sizes.NominalIndent
-	d.fpdf.SetLeftMargin(d.leftMargin)
-	d.fpdf.SetFillColor(r, g, b)
+// hr draws a horizontal rule across the content at the current position, in the theme's hr style, with the line styles given by style,
+// any of "thick", "double", "dashed", "dotted" and "short" (a third of the width, centred)
+func (d *Document) hr(style string) {
+	wd, _ := d.fpdf.GetPageSize()
+	_, _, rm, _ := d.fpdf.GetMargins()
+	left, right := d.leftMargin, wd-rm
+	width := d.fpdf.GetLineWidth()
+	r, g, b := d.fpdf.GetDrawColor()
+	w := width
+	if d.theme.Hr.LineWidth > 0 {
+		w = d.theme.Hr.LineWidth
+	}
+	if c, ok := d.color(d.theme.Hr.BorderColor); ok {
+		d.fpdf.SetDrawColor(c.r, c.g, c.b)
+	}
+	var double, dashed, dotted bool
+	for _, s := range strings.Fields(strings.ToLower(style)) {
+		switch s {
+		case "thick":
+			w *= 3
+		case "double":
+			double = true
+		case "dashed":
+			dashed = true
+		case "dotted":
+			dotted = true
+		case "short":
+			third := (right - left) / 3
+			left, right = left+third, right-third
+		default:
+			if !d.measuring {
+				fmt.Printf("[docgen] Unknown horizontal rule style: %v\r\n", s)
+			}
+		}
+	}
+	k := d.fpdf.GetConversionRatio()
+	switch {
+	case dotted:
+		// dashes of no length drawn with round ends are dots the width of the line
+		d.fpdf.SetLineCapStyle("round")
+		d.fpdf.SetDashPattern([]float64{0, 3 * w}, 0)
+	case dashed:
+		d.fpdf.SetDashPattern([]float64{dashLength / k, dashGap / k}, 0)
+	}
+	d.fpdf.SetLineWidth(w)
+	y := d.fpdf.GetY()
+	d.fpdf.Line(left, y, right, y)
+	if double {
+		d.fpdf.Line(left, y+3*w, right, y+3*w)
+	}
+	d.fpdf.SetDashPattern([]float64{}, 0)
+	d.fpdf.SetLineCapStyle("butt")
+	d.fpdf.SetLineWidth(width)
+	d.fpdf.SetDrawColor(r, g, b)
 	d.fpdf.Write(d.lineHeight, "\n")
 }
 
+// checkbox draws the box of a task list item in place of its bullet, sitting on the baseline of the text, with a tick in it if checked
+func (d *Document) checkbox(checked bool) {
+	size := checkboxSize * d.fontSize / d.fpdf.GetConversionRatio()
+	x := d.fpdf.GetX() + d.fpdf.GetCellMargin()
+	y := d.fpdf.GetY() + d.lineHeight/2 + (0.3*d.fontSize/d.fpdf.GetConversionRatio() - size)
+	width := d.fpdf.GetLineWidth()
+	r, g, b := d.fpdf.GetDrawColor()
+	d.fpdf.SetDrawColor(d.textColor.r, d.textColor.g, d.textColor.b)
+	d.fpdf.SetLineWidth(size / 12)
+	d.fpdf.Rect(x, y, size, size, "D")
+	if checked {
+		// drawing the path moves the current position, so it is put back after it
+		cx, cy := d.fpdf.GetXY()
+		d.fpdf.SetLineWidth(size / 7)
+		d.fpdf.MoveTo(x+0.2*size, y+0.5*size)
+		d.fpdf.LineTo(x+0.42*size, y+0.75*size)
+		d.fpdf.LineTo(x+0.8*size, y+0.22*size)
+		d.fpdf.DrawPath("D")
+		d.fpdf.SetXY(cx, cy)
+	}
+	d.fpdf.SetLineWidth(width)
+	d.fpdf.SetDrawColor(r, g, b)
+}
+
 func (d *Document) tableMulti() {
 	line := d.fpdf.GetLineWidth()
+	margin := d.fpdf.GetCellMargin()
+	dr, dg, db := d.fpdf.GetDrawColor()
+	fr, fg, fb := d.fpdf.GetFillColor()
 
 	d.fpdf.SetLineWidth(line / 4)
-	_, pageh := d.fpdf.GetPageSize()
-	_, _, _, mbottom := d.fpdf.GetMargins()
+	if d.theme.Table.LineWidth > 0 {
+		d.fpdf.SetLineWidth(d.theme.Table.LineWidth)
+	}
+	if c, ok := d.color(d.theme.Table.BorderColor); ok {
+		d.fpdf.SetDrawColor(c.r, c.g, c.b)
+	}
+	d.fpdf.SetCellMargin(d.table.padH)
+	d.pushStyle(d.theme.Table)
 
+	d.mergeTableCells()
 	cols, lmarg := d.calcTableColumnWidths()
 	if d.table.align == rules.AlignCenter {
 		lmarg = lmarg / 2
 	} else if d.table.align == rules.AlignLeft {
 		lmarg = 0
 	}
+	heights := d.calcTableRowHeights(cols)
 
-	for _, row := range d.table.rows {
-		curx, y := d.fpdf.GetXY()
-		x := curx + lmarg
-		d.fpdf.SetX(x)
+	if d.table.caption != nil {
+		// keep the caption on the same page as the start of its table
+		if !d.pageTemplate && len(heights) > 0 && d.fpdf.GetY()+d.lineHeight+heights[0] > d.pageBottom() {
+			d.pageBreak()
+		}
+		d.tableCaption(d.table.caption)
+		d.table.caption = nil
+	}
 
+	for r := 0; r < len(d.table.rows); {
+		// rows joined by a row span are kept together on the same page
+		end := r + 1
+		for i := r; i < end; i++ {
+			for _, cell := range d.table.rows[i] {
+				if i+cell.rowSpan > end {
+					end = i + cell.rowSpan
+				}
+			}
+		}
 		height := 0.0
+		for _, h := range heights[r:end] {
+			height += h
+		}
 
-		for i, cell := range row {
-			if cell.head {
-				d.applyStyle("B")
+		// move to a new column or page if the height of the rows doesn't fit
+		if !d.pageTemplate && d.fpdf.GetY()+height > d.pageBottom() {
+			d.pageBreak()
+		}
+		curx := d.fpdf.GetX()
+		y := d.fpdf.GetY()
+
+		for ; r < end; r++ {
+			x := curx + lmarg
+			for i, cell := range d.table.rows[r] {
+				if i >= len(cols) {
+					break
+				}
+				if cell.colSpan == 0 {
+					x += cols[i]
+					continue
+				}
+				width := spanSum(cols, i, cell.colSpan)
+				cellHeight := spanSum(heights, r, cell.rowSpan)
+				if c, ok := d.color(d.cellStyle(cell).Background); ok {
+					d.fpdf.SetFillColor(c.r, c.g, c.b)
+					d.fpdf.Rect(x, y, width, cellHeight, "F")
+				}
+				if d.table.lines {
+					d.fpdf.Rect(x, y, width, cellHeight, "")
+				}
+				offset := d.table.padV
+				textHeight := float64(d.tableCellLines(cell, width)) * (d.lineHeight + d.table.lineGap)
+				switch d.table.valign {
+				case rules.VAlignMiddle:
+					offset = (cellHeight - textHeight) / 2
+				case rules.VAlignBottom:
+					offset = cellHeight - textHeight - d.table.padV
+				}
+				d.pushStyle(d.cellStyle(cell))
+				d.fpdf.SetXY(x, y+offset)
+				text := cell.text
+				if d.hyphenator != nil {
+					text = strings.Join(d.wrapLines(text, width), "\n")
+				}
+				d.fpdf.MultiCell(width, d.lineHeight+d.table.lineGap, text, "", "", false)
+				x += cols[i]
+				d.popStyle()
+			}
+			y += heights[r]
+		}
+		d.fpdf.SetXY(curx, y)
+	}
+
+	d.popStyle()
+	d.fpdf.SetCellMargin(margin)
+	d.fpdf.SetLineWidth(line)
+	d.fpdf.SetDrawColor(dr, dg, db)
+	d.fpdf.SetFillColor(fr, fg, fb)
+}
+
+// cellStyle gives the theme style of a table cell, header cells are styled on top of the style of the table
+func (d *Document) cellStyle(cell cell) Style {
+	if cell.head {
+		return d.theme.TableHeader
+	}
+	return d.theme.Table
+}
+
+// tableCaption prints a table caption on its own line, aligned the same as the table, numbered unless it is in a page header or footer
+func (d *Document) tableCaption(tk *rules.TableCaption) {
+	text := tableReferences.ReplaceAllStringFunc(tk.Text, func(ref string) string {
+		return d.tableReference(tableReferences.FindStringSubmatch(ref)[1])
+	})
+	if tk.Number > 0 {
+		label := fmt.Sprintf("Table %v", tk.Number)
+		if text != "" {
+			label += ": " + text
+		}
+		text = label
+	}
+	align := "C"
+	switch d.table.align {
+	case rules.AlignLeft:
+		align = "L"
+	case rules.AlignRight:
+		align = "R"
+	}
+	d.applyStyle("I")
+	d.fpdf.SetX(d.leftMargin)
+	d.fpdf.WriteAligned(0, d.lineHeight, text, align)
+	d.fpdf.Ln(d.lineHeight)
+	d.removeStyle("I")
+}
+
+var tableReferences = regexp.MustCompile(`\\tref\{([^}]*)\}`)
+
+// tableReference looks up the number of the table with the caption label, or ?? if there is none
+func (d *Document) tableReference(label string) string {
+	num, ok := d.table.labels[strings.TrimSpace(label)]
+	if !ok {
+		fmt.Printf("[docgen] Unknown table reference: %v\r\n", label)
+		return "??"
+	}
+	return strconv.Itoa(num)
+}
+
+// mergeTableCells resolves merge markers in the table, setting the column and row span of each cell
+// a cell containing only cellMergeLeft joins the cell to its left, and one containing only cellMergeUp the cell above
+// markers that would not leave the merged cell rectangular are printed as ordinary text
+func (d *Document) mergeTableCells() {
+	rows := d.table.rows
+	type position struct{ row, col int }
+	owners := make([][]position, len(rows))
+	owner := func(r, c int) (position, bool) {
+		if r < 0 || c < 0 || c >= len(owners[r]) {
+			return position{}, false
+		}
+		return owners[r][c], true
+	}
+	isMarker := func(r, c int) bool {
+		return c < len(rows[r]) && !rows[r][c].literal && isMergeMarker(rows[r][c].text)
+	}
+	covers := func(o position, r, c int) bool {
+		own := rows[o.row][o.col]
+		return r >= o.row && r < o.row+own.rowSpan && c >= o.col && c < o.col+own.colSpan
+	}
+
+	for r := range rows {
+		owners[r] = make([]position, len(rows[r]))
+		for c := range rows[r] {
+			owners[r][c] = position{r, c}
+			rows[r][c].colSpan = 1
+			rows[r][c].rowSpan = 1
+
+			var o position
+			var ok bool
+			marker := ""
+			if isMarker(r, c) {
+				marker = strings.TrimSpace(rows[r][c].text)
 			}
-			lines := d.fpdf.SplitLines([]byte(cell.text), cols[i])
-			h := float64(len(lines))*d.lineHeight + float64(int(d.sizes.CellMargin)*len(lines))
-			if h > height {
-				height = h
+			switch marker {
+			case cellMergeLeft:
+				o, ok = owner(r, c-1)
+				if ok && !covers(o, r, c) {
+					own := &rows[o.row][o.col]
+					// only extend across the owners first row, later rows must follow the established span
+					ok = o.row == r && o.col+own.colSpan == c
+					if ok {
+						own.colSpan++
+					}
+				}
+			case cellMergeUp:
+				if r == 0 {
+					break
+				}
+				o, ok = owner(r-1, c)
+				if ok && !covers(o, r, c) {
+					own := &rows[o.row][o.col]
+					ok = o.col == c && o.row+own.rowSpan == r
+					for i := c + 1; ok && i < c+own.colSpan; i++ {
+						ok = isMarker(r, i)
+					}
+					if ok {
+						own.rowSpan++
+					}
+				}
 			}
-			if cell.head {
-				d.removeStyle("B")
+			if ok {
+				owners[r][c] = o
+				rows[r][c].colSpan = 0
+				rows[r][c].rowSpan = 0
 			}
 		}
-		// add a new page if the height of the row doesn't fit on the page
-		if d.fpdf.GetY()+height > pageh-mbottom {
-			d.fpdf.AddPage()
-			y = d.fpdf.GetY()
-		}
+	}
+}
+
+// tableCellLines counts the lines of text in a table cell when wrapped to width
+func (d *Document) tableCellLines(cell cell, width float64) int {
+	d.pushStyle(d.cellStyle(cell))
+	lines := len(d.wrapLines(cell.text, width))
+	d.popStyle()
+	if lines < 1 {
+		lines = 1
+	}
+	return lines
+}
+
+// calcTableRowHeights measures the height of each table row including cell padding, growing the last row of any row span so the spanning cell fits
+func (d *Document) calcTableRowHeights(cols []float64) []float64 {
+	heights := make([]float64, len(d.table.rows))
+	cellHeight := func(cell cell, width float64) float64 {
+		return float64(d.tableCellLines(cell, width))*(d.lineHeight+d.table.lineGap) + 2*d.table.padV
+	}
+
+	for r, row := range d.table.rows {
 		for i, cell := range row {
-			if cell.head {
-				d.applyStyle("B")
+			if i >= len(cols) || cell.rowSpan != 1 {
+				continue
+			}
+			h := cellHeight(cell, spanSum(cols, i, cell.colSpan))
+			if h > heights[r] {
+				heights[r] = h
 			}
-			width := cols[i]
-			if d.table.lines {
-				d.fpdf.Rect(x, y, width, height, "")
+		}
+	}
+	for r, row := range d.table.rows {
+		for i, cell := range row {
+			if i >= len(cols) || cell.rowSpan < 2 {
+				continue
 			}
-			d.fpdf.MultiCell(width, d.lineHeight+d.sizes.CellMargin, cell.text, "", "", false)
-			x += width
-			d.fpdf.SetXY(x, y)
-			if cell.head {
-				d.removeStyle("B")
+			last := r + cell.rowSpan - 1
+			h := cellHeight(cell, spanSum(cols, i, cell.colSpan))
+			if avail := spanSum(heights, r, cell.rowSpan); h > avail {
+				heights[last] += h - avail
 			}
 		}
-		d.fpdf.SetXY(curx, y+height)
 	}
+	return heights
+}
+
+// length converts a directive length to document units, with percentages taken of relative
+func (d *Document) length(l rules.Length, relative float64) float64 {
+	switch {
+	case l.Unit == "%":
+		return relative * l.Value / 100
+	case l.Absolute():
+		return d.fpdf.PointConvert(l.Points())
+	}
+	return l.Value
+}
 
-	d.fpdf.SetLineWidth(line)
+// spanSum totals count entries of vals starting at index start
+func spanSum(vals []float64, start, count int) float64 {
+	sum := 0.0
+	for i := start; i < start+count && i < len(vals); i++ {
+		sum += vals[i]
+	}
+	return sum
 }
 
 func (d *Document) calcTableColumnWidths() ([]float64, float64) {
 
-	wdspace := math.Ceil(d.fpdf.GetStringWidth(" "))
+	// the width of a space is rounded up to a whole point
+	k := d.fpdf.GetConversionRatio()
+	wdspace := math.Ceil(d.fpdf.GetStringWidth(" ")*k) / k
 
 	type column struct {
 		max     float64
@@ -389,22 +990,28 @@ func (d *Document) calcTableColumnWidths() ([]float64, float64) {
 		count   float64
 		average float64
 	}
+	type spanning struct {
+		col, span int
+		wd        float64
+	}
 	var c []column
+	var spans []spanning
 	for _, rowVal := range d.table.rows {
 		for col, colVal := range rowVal {
 			if len(c) < col+1 {
 				c = append(c, column{})
 			}
-
-			if colVal.head {
-				d.removeStyle("B")
-				d.flushTextStyling()
+			if colVal.colSpan == 0 {
+				continue
 			}
 
-			wd := d.fpdf.GetStringWidth(colVal.text) + (wdspace * float64(strings.Count(colVal.text, " ")+1+4))
-			if colVal.head {
-				d.removeStyle("B")
-				d.flushTextStyling()
+			d.pushStyle(d.cellStyle(colVal))
+			wd := d.fpdf.GetStringWidth(colVal.text) + (wdspace * float64(strings.Count(colVal.text, " ")+1+4)) + 2*d.table.padH
+			d.popStyle()
+			if colVal.colSpan > 1 {
+				// spanning cells are fitted once the single column widths are known
+				spans = append(spans, spanning{col: col, span: colVal.colSpan, wd: wd})
+				continue
 			}
 			if wd > c[col].max {
 				c[col].max = wd
@@ -414,6 +1021,24 @@ func (d *Document) calcTableColumnWidths() ([]float64, float64) {
 			c[col].average = c[col].total / c[col].count
 		}
 	}
+	for _, sp := range spans {
+		sum := 0.0
+		for i := sp.col; i < sp.col+sp.span && i < len(c); i++ {
+			sum += c[i].max
+		}
+		if sp.wd <= sum {
+			continue
+		}
+		extra := (sp.wd - sum) / float64(sp.span)
+		for i := sp.col; i < sp.col+sp.span && i < len(c); i++ {
+			c[i].max += extra
+		}
+	}
+	for i := range c {
+		if c[i].count == 0 {
+			c[i].average = c[i].max
+		}
+	}
 
 	total := float64(0)
 	avgTotal := float64(0)
@@ -426,16 +1051,32 @@ func (d *Document) calcTableColumnWidths() ([]float64, float64) {
 	lmarge, _, rmarge, _ := d.fpdf.GetMargins()
 
 	max := wpage - (lmarge + rmarge)
-	if d.table.size == rules.SizeHalf {
+	switch d.table.size {
+	case rules.SizeHalf:
 		max = max / 2
+	case rules.SizeFixed:
+		max = d.length(d.table.width, max)
 	}
 	var cols []float64
 	var sum float64
 	if len(d.table.cols) > 0 {
 		cols = append(cols, d.table.cols...)
+		// fixed width columns are taken first, with ratio columns sharing what remains
+		remaining := max
+		for i := range cols {
+			if i < len(d.table.colUnits) && d.table.colUnits[i] != "" {
+				cols[i] = d.length(rules.Length{Value: cols[i], Unit: d.table.colUnits[i]}, max)
+				remaining -= cols[i]
+			}
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
 		for i := range cols {
-			cols[i] = (cols[i] / d.table.colsum) * max
-			sum += (cols[i] / d.table.colsum) * max
+			if (i >= len(d.table.colUnits) || d.table.colUnits[i] == "") && d.table.colsum > 0 {
+				cols[i] = (cols[i] / d.table.colsum) * remaining
+			}
+			sum += cols[i]
 		}
 	} else {
 		if d.table.size == rules.SizeWrap && total <= (max) {
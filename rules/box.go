@@ -0,0 +1,257 @@
+package rules
+
+import (
+	"regexp"
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type BoxOpen struct {
+	lvl  int
+	Kind string // kind of callout, such as note or warning, empty for a plain box
+}
+
+type BoxClose struct {
+	lvl int
+}
+
+type BoxTitleOpen struct {
+	lvl int
+}
+
+type BoxTitleClose struct {
+	lvl int
+}
+
+func (j *BoxOpen) Tag() string {
+	return "box"
+}
+
+func (j *BoxOpen) Opening() bool {
+	return true
+}
+
+func (j *BoxOpen) Closing() bool {
+	return false
+}
+
+func (j *BoxOpen) Block() bool {
+	return true
+}
+
+func (j *BoxOpen) Level() int {
+	return j.lvl
+}
+
+func (j *BoxOpen) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *BoxClose) Tag() string {
+	return "box"
+}
+
+func (j *BoxClose) Opening() bool {
+	return false
+}
+
+func (j *BoxClose) Closing() bool {
+	return true
+}
+
+func (j *BoxClose) Block() bool {
+	return true
+}
+
+func (j *BoxClose) Level() int {
+	return j.lvl
+}
+
+func (j *BoxClose) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *BoxTitleOpen) Tag() string {
+	return "boxtitle"
+}
+
+func (j *BoxTitleOpen) Opening() bool {
+	return true
+}
+
+func (j *BoxTitleOpen) Closing() bool {
+	return false
+}
+
+func (j *BoxTitleOpen) Block() bool {
+	return true
+}
+
+func (j *BoxTitleOpen) Level() int {
+	return j.lvl
+}
+
+func (j *BoxTitleOpen) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *BoxTitleClose) Tag() string {
+	return "boxtitle"
+}
+
+func (j *BoxTitleClose) Opening() bool {
+	return false
+}
+
+func (j *BoxTitleClose) Closing() bool {
+	return true
+}
+
+func (j *BoxTitleClose) Block() bool {
+	return true
+}
+
+func (j *BoxTitleClose) Level() int {
+	return j.lvl
+}
+
+func (j *BoxTitleClose) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+// RuleBox parses the \box and \endbox directives around the content of a box
+// \box can be followed by the kind of callout, such as note or warning, and a title to use in place of the kind's label
+func RuleBox(s *markdown.StateBlock, startLine, endLine int, silent bool) (_ bool) {
+	shift := s.TShift[startLine]
+	if shift < 0 {
+		return
+	}
+
+	pos := s.BMarks[startLine] + shift
+	line := strings.TrimSpace(s.Src[pos:s.EMarks[startLine]])
+
+	if line == "\\endbox" {
+		if !silent {
+			s.Line = startLine + 1
+			s.PushToken(&BoxClose{})
+		}
+		return true
+	}
+	if line != "\\box" && !strings.HasPrefix(line, "\\box ") {
+		return
+	}
+
+	if silent {
+		return true
+	}
+
+	s.Line = startLine + 1
+	args := strings.SplitN(strings.TrimSpace(line[len("\\box"):]), " ", 2)
+	kind := strings.ToLower(args[0])
+	s.PushOpeningToken(&BoxOpen{Kind: kind})
+	if kind == "" {
+		return true
+	}
+	title := calloutLabel(kind)
+	if len(args) > 1 && strings.TrimSpace(args[1]) != "" {
+		title = strings.TrimSpace(args[1])
+	}
+	s.PushOpeningToken(&BoxTitleOpen{})
+	s.PushToken(&markdown.Inline{Content: title, Map: [2]int{startLine, startLine + 1}})
+	s.PushClosingToken(&BoxTitleClose{})
+
+	return true
+}
+
+var calloutMarker = regexp.MustCompile(`^\[!([A-Za-z]+)\][ \t]*`)
+
+// RuleCallout turns blockquotes starting with a line of the form [!NOTE] into callout boxes, once their inline content is parsed
+// the marker can be followed by a title to use in place of the kind's label, with the rest of the blockquote the content of the box
+func RuleCallout(s *markdown.StateCore) {
+	var tokens []markdown.Token
+	var closes []bool // for each open blockquote, if it is a callout
+	for i := 0; i < len(s.Tokens); i++ {
+		switch tk := s.Tokens[i].(type) {
+		case *markdown.BlockquoteOpen:
+			box, title, para := callout(s.Tokens[i:])
+			closes = append(closes, box != nil)
+			if box == nil {
+				break
+			}
+			box.lvl = tk.Lvl
+			tokens = append(tokens, box, &BoxTitleOpen{lvl: tk.Lvl + 1}, title, &BoxTitleClose{lvl: tk.Lvl + 1})
+			if para {
+				// the marker was all of the first paragraph
+				i += 3
+			}
+			continue
+		case *markdown.BlockquoteClose:
+			l := len(closes)
+			if l > 0 && closes[l-1] {
+				closes = closes[:l-1]
+				tokens = append(tokens, &BoxClose{lvl: tk.Lvl})
+				continue
+			}
+			if l > 0 {
+				closes = closes[:l-1]
+			}
+		}
+		tokens = append(tokens, s.Tokens[i])
+	}
+	s.Tokens = tokens
+}
+
+// callout reads the callout marker at the start of the blockquote opened by the first of tokens, giving the box and its title,
+// and removing the marker line from the blockquote's first paragraph, or reporting if it was the whole paragraph
+func callout(tokens []markdown.Token) (box *BoxOpen, title *markdown.Inline, para bool) {
+	if len(tokens) < 4 {
+		return
+	}
+	if _, ok := tokens[1].(*markdown.ParagraphOpen); !ok {
+		return
+	}
+	il, ok := tokens[2].(*markdown.Inline)
+	if !ok || len(il.Children) == 0 {
+		return
+	}
+	text, ok := il.Children[0].(*markdown.Text)
+	if !ok {
+		return
+	}
+	m := calloutMarker.FindStringSubmatch(text.Content)
+	if m == nil || !strings.HasPrefix(il.Content, m[0]) {
+		return
+	}
+	kind := strings.ToLower(m[1])
+	text.Content = text.Content[len(m[0]):]
+
+	// the title runs to the end of the marker line, with the rest of the paragraph left as the start of the content
+	title = &markdown.Inline{Map: il.Map, Children: il.Children}
+	var rest []markdown.Token
+	for i, child := range il.Children {
+		_, soft := child.(*markdown.Softbreak)
+		_, hard := child.(*markdown.Hardbreak)
+		if soft || hard {
+			title.Children, rest = il.Children[:i], il.Children[i+1:]
+			break
+		}
+	}
+	line := strings.SplitN(il.Content, "\n", 2)
+	title.Content = strings.TrimSpace(line[0][len(m[0]):])
+	if title.Content == "" {
+		title.Content = calloutLabel(kind)
+		title.Children = []markdown.Token{&markdown.Text{Content: title.Content}}
+	}
+	il.Children = rest
+	if len(line) > 1 {
+		il.Content = line[1]
+	}
+
+	return &BoxOpen{Kind: kind}, title, len(rest) == 0
+}
+
+// calloutLabel gives the label written at the top of a callout of a kind, the kind with its first letter capitalised
+func calloutLabel(kind string) string {
+	return strings.ToUpper(kind[:1]) + kind[1:]
+}
@@ -0,0 +1,205 @@
+package rules
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type ColorOpen struct {
+	lvl       int
+	Color     string // text colour, empty to leave it unchanged
+	Highlight string // background colour, empty for none
+	Mark      bool   // marked with ==, highlighted in the theme's highlight style
+}
+
+type ColorClose struct {
+	lvl int
+}
+
+func (j *ColorOpen) Tag() string {
+	return "span"
+}
+
+func (j *ColorOpen) Opening() bool {
+	return true
+}
+
+func (j *ColorOpen) Closing() bool {
+	return false
+}
+
+func (j *ColorOpen) Block() bool {
+	return false
+}
+
+func (j *ColorOpen) Level() int {
+	return j.lvl
+}
+
+func (j *ColorOpen) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *ColorClose) Tag() string {
+	return "span"
+}
+
+func (j *ColorClose) Opening() bool {
+	return false
+}
+
+func (j *ColorClose) Closing() bool {
+	return true
+}
+
+func (j *ColorClose) Block() bool {
+	return false
+}
+
+func (j *ColorClose) Level() int {
+	return j.lvl
+}
+
+func (j *ColorClose) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+const colorEnd = "{/}"
+
+// RuleColor colours text between a tag of the form {color=red highlight=#ff0} and {/}
+func RuleColor(s *markdown.StateInline, silent bool) (_ bool) {
+	src := s.Src
+	max := s.PosMax
+	start := s.Pos
+
+	if silent || src[start] != '{' {
+		return
+	}
+	open, length, ok := parseColorTag(src[start:max])
+	if !ok {
+		return
+	}
+
+	// find the matching end tag, skipping over any nested tags
+	s.Pos = start + length
+	depth := 0
+	found := false
+	for s.Pos < max {
+		if src[s.Pos] == '{' {
+			if strings.HasPrefix(src[s.Pos:max], colorEnd) {
+				if depth == 0 {
+					found = true
+					break
+				}
+				depth--
+				s.Pos += len(colorEnd)
+				continue
+			}
+			if _, length, ok := parseColorTag(src[s.Pos:max]); ok {
+				depth++
+				s.Pos += length
+				continue
+			}
+		}
+		s.Md.Inline.SkipToken(s)
+	}
+
+	if !found {
+		s.Pos = start
+		return
+	}
+
+	s.PosMax = s.Pos
+	s.Pos = start + length
+
+	s.PushOpeningToken(&open)
+
+	s.Md.Inline.Tokenize(s)
+
+	s.PushClosingToken(&ColorClose{})
+
+	s.Pos = s.PosMax + len(colorEnd)
+	s.PosMax = max
+
+	return true
+}
+
+// parseColorTag reads a colour tag at the start of str, giving its length
+func parseColorTag(str string) (open ColorOpen, length int, ok bool) {
+	end := strings.IndexAny(str, "}\n")
+	if end < 0 || str[end] != '}' {
+		return
+	}
+	args := strings.Fields(str[1:end])
+	if len(args) == 0 {
+		return
+	}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return
+		}
+		switch strings.ToLower(parts[0]) {
+		case "color", "colour":
+			open.Color = parts[1]
+		case "highlight":
+			open.Highlight = parts[1]
+		default:
+			return
+		}
+	}
+	return open, end + 1, true
+}
+
+// RuleHighlight highlights text between == markers
+func RuleHighlight(s *markdown.StateInline, silent bool) (_ bool) {
+	src := s.Src
+	max := s.PosMax
+	start := s.Pos
+
+	if silent || src[start] != '=' {
+		return
+	}
+
+	canOpen, _, count := scanDelims(s, start)
+	if count != 2 || !canOpen {
+		s.Pos += count
+		s.Pending.WriteString(src[start:s.Pos])
+		return true
+	}
+
+	s.Pos += count
+	found := false
+	for s.Pos < max {
+		if src[s.Pos] == '=' {
+			_, canClose, count := scanDelims(s, s.Pos)
+			if count == 2 && canClose {
+				found = true
+				break
+			}
+			s.Pos += count
+			continue
+		}
+		s.Md.Inline.SkipToken(s)
+	}
+
+	if !found {
+		s.Pos = start
+		return
+	}
+
+	s.PosMax = s.Pos
+	s.Pos = start + 2
+
+	s.PushOpeningToken(&ColorOpen{Mark: true})
+
+	s.Md.Inline.Tokenize(s)
+
+	s.PushClosingToken(&ColorClose{})
+
+	s.Pos = s.PosMax + 2
+	s.PosMax = max
+
+	return true
+}
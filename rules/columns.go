@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type Columns struct {
+	lvl    int
+	Count  int    // number of columns, 1 to return to a single column
+	Gutter Length // space between columns, a zero length for the default
+}
+
+func (j *Columns) Tag() string {
+	return "columns"
+}
+
+func (j *Columns) Opening() bool {
+	return true
+}
+
+func (j *Columns) Closing() bool {
+	return true
+}
+
+func (j *Columns) Block() bool {
+	return true
+}
+
+func (j *Columns) Level() int {
+	return j.lvl
+}
+
+func (j *Columns) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func RuleColumns(s *markdown.StateBlock, startLine, endLine int, silent bool) (_ bool) {
+	shift := s.TShift[startLine]
+	if shift < 0 {
+		return
+	}
+
+	pos := s.BMarks[startLine] + shift
+	line := s.Src[pos:s.EMarks[startLine]]
+
+	if !strings.HasPrefix(line, "\\columns") {
+		return
+	}
+	line = line[len("\\columns"):]
+	if line != "" && !unicode.IsSpace(rune(line[0])) {
+		return
+	}
+
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		if !silent {
+			fmt.Printf("[docgen] Error parsing columns: expected a column count\r\n")
+		}
+		return
+	}
+	if len(args) > 2 {
+		if !silent {
+			fmt.Printf("[docgen] Error parsing columns: expected at most 2 arguments, got %v\r\n", len(args))
+		}
+		return
+	}
+	count, err := strconv.Atoi(args[0])
+	if err != nil || count < 1 {
+		if !silent {
+			fmt.Printf("[docgen] Error parsing column count: %v\r\n", args[0])
+		}
+		return
+	}
+	tok := Columns{Count: count}
+	if len(args) > 1 {
+		tok.Gutter, err = ParseLength(args[1])
+		if err == nil && tok.Gutter.Unit == "%" {
+			err = fmt.Errorf("invalid length %q: gutter can't be a percentage", args[1])
+		}
+		if err != nil {
+			if !silent {
+				fmt.Printf("[docgen] Error parsing column gutter: %v\r\n", err)
+			}
+			return
+		}
+	}
+
+	if silent {
+		return true
+	}
+
+	s.Line = startLine + 1
+	s.PushToken(&tok)
+
+	return true
+}
@@ -0,0 +1,247 @@
+package rules
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type DefinitionListOpen struct {
+	lvl   int
+	Terms []*markdown.Inline // the terms of the list, to line up their definitions
+}
+
+type DefinitionListClose struct {
+	lvl int
+}
+
+type DefinitionTermOpen struct {
+	lvl int
+}
+
+type DefinitionTermClose struct {
+	lvl int
+}
+
+type DefinitionOpen struct {
+	lvl int
+}
+
+type DefinitionClose struct {
+	lvl int
+}
+
+func (j *DefinitionListOpen) Tag() string {
+	return "dl"
+}
+
+func (j *DefinitionListOpen) Opening() bool {
+	return true
+}
+
+func (j *DefinitionListOpen) Closing() bool {
+	return false
+}
+
+func (j *DefinitionListOpen) Block() bool {
+	return true
+}
+
+func (j *DefinitionListOpen) Level() int {
+	return j.lvl
+}
+
+func (j *DefinitionListOpen) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *DefinitionListClose) Tag() string {
+	return "dl"
+}
+
+func (j *DefinitionListClose) Opening() bool {
+	return false
+}
+
+func (j *DefinitionListClose) Closing() bool {
+	return true
+}
+
+func (j *DefinitionListClose) Block() bool {
+	return true
+}
+
+func (j *DefinitionListClose) Level() int {
+	return j.lvl
+}
+
+func (j *DefinitionListClose) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *DefinitionTermOpen) Tag() string {
+	return "dt"
+}
+
+func (j *DefinitionTermOpen) Opening() bool {
+	return true
+}
+
+func (j *DefinitionTermOpen) Closing() bool {
+	return false
+}
+
+func (j *DefinitionTermOpen) Block() bool {
+	return true
+}
+
+func (j *DefinitionTermOpen) Level() int {
+	return j.lvl
+}
+
+func (j *DefinitionTermOpen) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *DefinitionTermClose) Tag() string {
+	return "dt"
+}
+
+func (j *DefinitionTermClose) Opening() bool {
+	return false
+}
+
+func (j *DefinitionTermClose) Closing() bool {
+	return true
+}
+
+func (j *DefinitionTermClose) Block() bool {
+	return true
+}
+
+func (j *DefinitionTermClose) Level() int {
+	return j.lvl
+}
+
+func (j *DefinitionTermClose) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *DefinitionOpen) Tag() string {
+	return "dd"
+}
+
+func (j *DefinitionOpen) Opening() bool {
+	return true
+}
+
+func (j *DefinitionOpen) Closing() bool {
+	return false
+}
+
+func (j *DefinitionOpen) Block() bool {
+	return true
+}
+
+func (j *DefinitionOpen) Level() int {
+	return j.lvl
+}
+
+func (j *DefinitionOpen) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *DefinitionClose) Tag() string {
+	return "dd"
+}
+
+func (j *DefinitionClose) Opening() bool {
+	return false
+}
+
+func (j *DefinitionClose) Closing() bool {
+	return true
+}
+
+func (j *DefinitionClose) Block() bool {
+	return true
+}
+
+func (j *DefinitionClose) Level() int {
+	return j.lvl
+}
+
+func (j *DefinitionClose) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+// RuleDefinitionList parses a list of terms, each on a line followed by one or more definitions on lines starting with a colon
+// a definition continues on the lines after it up to a blank line, with the next term of the list following the blank line
+func RuleDefinitionList(s *markdown.StateBlock, startLine, endLine int, silent bool) (_ bool) {
+	if !isTermLine(s, startLine, endLine) {
+		return
+	}
+
+	if silent {
+		return true
+	}
+
+	list := &DefinitionListOpen{}
+	s.PushOpeningToken(list)
+
+	line := startLine
+	for isTermLine(s, line, endLine) {
+		s.PushOpeningToken(&DefinitionTermOpen{})
+		term := &markdown.Inline{Content: strings.TrimSpace(lineText(s, line)), Map: [2]int{line, line + 1}}
+		s.PushToken(term)
+		s.PushClosingToken(&DefinitionTermClose{})
+		list.Terms = append(list.Terms, term)
+		line++
+
+		for isDefinitionLine(s, line, endLine) {
+			start := line
+			content := []string{strings.TrimSpace(lineText(s, line)[1:])}
+			line++
+			for line < endLine && !s.IsLineEmpty(line) && !isDefinitionLine(s, line, endLine) {
+				content = append(content, strings.TrimSpace(lineText(s, line)))
+				line++
+			}
+			s.PushOpeningToken(&DefinitionOpen{})
+			s.PushToken(&markdown.Inline{Content: strings.Join(content, "\n"), Map: [2]int{start, line}})
+			s.PushClosingToken(&DefinitionClose{})
+		}
+
+		next := s.SkipEmptyLines(line)
+		if next >= endLine || !isTermLine(s, next, endLine) {
+			break
+		}
+		line = next
+	}
+
+	s.PushClosingToken(&DefinitionListClose{})
+	s.Line = line
+
+	return true
+}
+
+// lineText gives the text of a line of the block, without its indent
+func lineText(s *markdown.StateBlock, line int) string {
+	return s.Src[s.BMarks[line]+s.TShift[line] : s.EMarks[line]]
+}
+
+// isDefinitionLine reports if a line is a definition, starting with a colon followed by a space
+func isDefinitionLine(s *markdown.StateBlock, line, endLine int) bool {
+	if line >= endLine || s.IsLineEmpty(line) || s.SCount[line]-s.BlkIndent >= 4 {
+		return false
+	}
+	text := lineText(s, line)
+	return len(text) > 1 && text[0] == ':' && (text[1] == ' ' || text[1] == '\t')
+}
+
+// isTermLine reports if a line is a term, directly followed by a definition
+func isTermLine(s *markdown.StateBlock, line, endLine int) bool {
+	if line+1 >= endLine || s.IsLineEmpty(line) || s.SCount[line]-s.BlkIndent >= 4 || isDefinitionLine(s, line, endLine) {
+		return false
+	}
+	return isDefinitionLine(s, line+1, endLine)
+}
@@ -0,0 +1,137 @@
+package rules
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type Footnote struct {
+	lvl   int
+	Label string
+	Text  string // markdown of the footnote, with its further lines unindented
+}
+
+type FootnoteReference struct {
+	lvl    int
+	Label  string
+	Number int // footnote number, assigned once the document is parsed, 0 if the footnote isn't defined
+}
+
+func (j *Footnote) Tag() string {
+	return "footnote"
+}
+
+func (j *Footnote) Opening() bool {
+	return true
+}
+
+func (j *Footnote) Closing() bool {
+	return true
+}
+
+func (j *Footnote) Block() bool {
+	return true
+}
+
+func (j *Footnote) Level() int {
+	return j.lvl
+}
+
+func (j *Footnote) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *FootnoteReference) Tag() string {
+	return "fnref"
+}
+
+func (j *FootnoteReference) Opening() bool {
+	return true
+}
+
+func (j *FootnoteReference) Closing() bool {
+	return true
+}
+
+func (j *FootnoteReference) Block() bool {
+	return false
+}
+
+func (j *FootnoteReference) Level() int {
+	return j.lvl
+}
+
+func (j *FootnoteReference) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+// RuleFootnote parses a footnote of the form [^label]: text
+// the footnote continues on following lines indented by 4 spaces, which can be separated by blank lines for further paragraphs
+func RuleFootnote(s *markdown.StateBlock, startLine, endLine int, silent bool) (_ bool) {
+	shift := s.TShift[startLine]
+	if shift < 0 || s.SCount[startLine]-s.BlkIndent >= 4 {
+		return
+	}
+
+	pos := s.BMarks[startLine] + shift
+	line := s.Src[pos:s.EMarks[startLine]]
+
+	label, length := footnoteLabel(line)
+	if length == 0 || length >= len(line) || line[length] != ':' {
+		return
+	}
+
+	if silent {
+		return true
+	}
+
+	body := []string{strings.TrimSpace(line[length+1:])}
+	last := startLine
+	for next := startLine + 1; next < endLine; next++ {
+		if s.IsLineEmpty(next) {
+			continue
+		}
+		if s.SCount[next]-s.BlkIndent < 4 {
+			break
+		}
+		if next > last+1 {
+			// blank lines separate paragraphs of the footnote
+			body = append(body, "")
+		}
+		body = append(body, s.Src[s.BMarks[next]+s.TShift[next]:s.EMarks[next]])
+		last = next
+	}
+
+	s.Line = last + 1
+	s.PushToken(&Footnote{Label: label, Text: strings.Join(body, "\n")})
+
+	return true
+}
+
+// RuleFootnoteReference parses a reference to a footnote of the form [^label]
+func RuleFootnoteReference(s *markdown.StateInline, silent bool) (_ bool) {
+	label, length := footnoteLabel(s.Src[s.Pos:s.PosMax])
+	if length == 0 {
+		return
+	}
+
+	if !silent {
+		s.PushToken(&FootnoteReference{Label: label})
+	}
+	s.Pos += length
+
+	return true
+}
+
+// footnoteLabel reads a footnote label of the form [^label] at the start of str, giving the length of the label and its brackets
+func footnoteLabel(str string) (label string, length int) {
+	if !strings.HasPrefix(str, "[^") {
+		return
+	}
+	end := strings.IndexByte(str, ']')
+	if end < 3 || strings.ContainsAny(str[2:end], " \t\n[") {
+		return
+	}
+	return str[2:end], end + 1
+}
@@ -66,7 +66,6 @@ func RuleHideText(s *markdown.StateInline, silent bool) (_ bool) {
 		}
 		s.Pos++
 	}
-	s.PosMax = s.Pos
 
 	s.PushOpeningToken(&open)
 
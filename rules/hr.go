@@ -0,0 +1,59 @@
+package rules
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type Hr struct {
+	lvl   int
+	Style string // space separated styles of the rule, such as "double" or "dashed short", in place of the theme's
+}
+
+func (j *Hr) Tag() string {
+	return "hr"
+}
+
+func (j *Hr) Opening() bool {
+	return true
+}
+
+func (j *Hr) Closing() bool {
+	return true
+}
+
+func (j *Hr) Block() bool {
+	return true
+}
+
+func (j *Hr) Level() int {
+	return j.lvl
+}
+
+func (j *Hr) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+// RuleHr parses the \hr directive, a horizontal rule in the styles that follow it, i.e. \hr thick or \hr dashed short
+func RuleHr(s *markdown.StateBlock, startLine, endLine int, silent bool) (_ bool) {
+	shift := s.TShift[startLine]
+	if shift < 0 {
+		return
+	}
+
+	pos := s.BMarks[startLine] + shift
+	line := strings.TrimSpace(s.Src[pos:s.EMarks[startLine]])
+	if line != "\\hr" && !strings.HasPrefix(line, "\\hr ") && !strings.HasPrefix(line, "\\hr\t") {
+		return
+	}
+
+	if silent {
+		return true
+	}
+
+	s.Line = startLine + 1
+	s.PushToken(&Hr{Style: strings.Join(strings.Fields(line[len("\\hr"):]), " ")})
+
+	return true
+}
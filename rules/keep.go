@@ -0,0 +1,92 @@
+package rules
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type KeepOpen struct {
+	lvl int
+}
+
+type KeepClose struct {
+	lvl int
+}
+
+func (j *KeepOpen) Tag() string {
+	return "keep"
+}
+
+func (j *KeepOpen) Opening() bool {
+	return true
+}
+
+func (j *KeepOpen) Closing() bool {
+	return false
+}
+
+func (j *KeepOpen) Block() bool {
+	return true
+}
+
+func (j *KeepOpen) Level() int {
+	return j.lvl
+}
+
+func (j *KeepOpen) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *KeepClose) Tag() string {
+	return "keep"
+}
+
+func (j *KeepClose) Opening() bool {
+	return false
+}
+
+func (j *KeepClose) Closing() bool {
+	return true
+}
+
+func (j *KeepClose) Block() bool {
+	return true
+}
+
+func (j *KeepClose) Level() int {
+	return j.lvl
+}
+
+func (j *KeepClose) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func RuleKeep(s *markdown.StateBlock, startLine, endLine int, silent bool) (_ bool) {
+	shift := s.TShift[startLine]
+	if shift < 0 {
+		return
+	}
+
+	pos := s.BMarks[startLine] + shift
+	line := strings.TrimSpace(s.Src[pos:s.EMarks[startLine]])
+
+	var tok markdown.Token
+	switch line {
+	case "\\keep":
+		tok = &KeepOpen{}
+	case "\\endkeep":
+		tok = &KeepClose{}
+	default:
+		return
+	}
+
+	if silent {
+		return true
+	}
+
+	s.Line = startLine + 1
+	s.PushToken(tok)
+
+	return true
+}
@@ -0,0 +1,58 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Length is a measurement given in a directive, a Unit of "" is a plain number or ratio, and "%" a percentage of the available space
+type Length struct {
+	Value float64
+	Unit  string
+}
+
+// points per unit of each supported absolute unit
+var unitPoints = map[string]float64{
+	"pt": 1,
+	"mm": 72 / 25.4,
+	"cm": 72 / 2.54,
+	"in": 72,
+	"px": 0.75,
+}
+
+// Absolute reports if the length is a fixed measurement rather than a ratio or percentage
+func (l Length) Absolute() bool {
+	_, ok := unitPoints[l.Unit]
+	return ok
+}
+
+// Points converts an absolute length to points, ratios and percentages are returned unchanged
+func (l Length) Points() float64 {
+	if k, ok := unitPoints[l.Unit]; ok {
+		return l.Value * k
+	}
+	return l.Value
+}
+
+// ParseLength reads a number with an optional unit suffix of pt, mm, cm, in, px (a 96th of an inch) or %
+func ParseLength(str string) (Length, error) {
+	l := Length{}
+	num := str
+	for _, unit := range []string{"pt", "mm", "cm", "in", "px", "%"} {
+		if strings.HasSuffix(str, unit) {
+			l.Unit = unit
+			num = strings.TrimSuffix(str, unit)
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return l, fmt.Errorf("invalid length %q: %v", str, err)
+	}
+	if f < 0 {
+		return l, fmt.Errorf("invalid length %q: must not be negative", str)
+	}
+	l.Value = f
+	return l, nil
+}
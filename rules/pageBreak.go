@@ -1,9 +1,15 @@
 package rules
 
-import "gitlab.com/golang-commonmark/markdown"
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
 
 type PageBreak struct {
-	lvl int
+	lvl         int
+	Orientation string // "P" or "L" to change the orientation of the following pages, empty to keep the current orientation
+	Paper       string // paper size for the following pages, empty to keep the current size
 }
 
 func (j *PageBreak) Tag() string {
@@ -39,18 +45,38 @@ func RulePageBreak(s *markdown.StateBlock, startLine, endLine int, silent bool)
 	pos := s.BMarks[startLine] + shift
 	src := s.Src
 
+	if len(src) < pos+5 {
+		return
+	}
+
 	marker := src[pos : pos+5]
 
 	if marker != "\\page" {
 		return
 	}
+	args := src[pos+5 : s.EMarks[startLine]]
+	if args != "" && args[0] != ' ' && args[0] != '\t' {
+		return
+	}
 
 	if silent {
 		return true
 	}
 
+	tok := PageBreak{}
+	for _, arg := range strings.Fields(args) {
+		switch strings.ToLower(arg) {
+		case "portrait":
+			tok.Orientation = "P"
+		case "landscape":
+			tok.Orientation = "L"
+		default:
+			tok.Paper = arg
+		}
+	}
+
 	s.Line = startLine + 1
-	s.PushToken(&PageBreak{})
+	s.PushToken(&tok)
 
 	return true
 }
@@ -0,0 +1,130 @@
+package rules
+
+import (
+	"strings"
+	"unicode"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type ScriptOpen struct {
+	lvl int
+	Sub bool // subscript, otherwise superscript
+}
+
+type ScriptClose struct {
+	lvl int
+	Sub bool
+}
+
+func (j *ScriptOpen) Tag() string {
+	if j.Sub {
+		return "sub"
+	}
+	return "sup"
+}
+
+func (j *ScriptOpen) Opening() bool {
+	return true
+}
+
+func (j *ScriptOpen) Closing() bool {
+	return false
+}
+
+func (j *ScriptOpen) Block() bool {
+	return false
+}
+
+func (j *ScriptOpen) Level() int {
+	return j.lvl
+}
+
+func (j *ScriptOpen) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *ScriptClose) Tag() string {
+	if j.Sub {
+		return "sub"
+	}
+	return "sup"
+}
+
+func (j *ScriptClose) Opening() bool {
+	return false
+}
+
+func (j *ScriptClose) Closing() bool {
+	return true
+}
+
+func (j *ScriptClose) Block() bool {
+	return false
+}
+
+func (j *ScriptClose) Level() int {
+	return j.lvl
+}
+
+func (j *ScriptClose) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+// RuleScript raises text between ^ markers as superscript, and lowers text between single ~ markers as subscript, i.e. m^2^ or CO~2~
+// the text between the markers can't be empty or contain spaces, unless they are escaped with a backslash
+func RuleScript(s *markdown.StateInline, silent bool) (_ bool) {
+	src := s.Src
+	max := s.PosMax
+	start := s.Pos
+	marker := src[start]
+
+	if silent || (marker != '^' && marker != '~') {
+		return
+	}
+	if marker == '~' && ((start+1 < max && src[start+1] == '~') || (start > 0 && src[start-1] == '~')) {
+		// ~~ is strikethrough
+		return
+	}
+
+	end := -1
+	for pos := start + 1; pos < max; pos++ {
+		c := src[pos]
+		if c == '\\' {
+			pos++
+			continue
+		}
+		if c == marker {
+			end = pos
+			break
+		}
+		if unicode.IsSpace(rune(c)) {
+			return
+		}
+	}
+	if end <= start+1 || (marker == '~' && end+1 < max && src[end+1] == '~') {
+		return
+	}
+
+	s.PosMax = end
+	s.Pos = start + 1
+
+	s.PushOpeningToken(&ScriptOpen{Sub: marker == '~'})
+	first := len(s.Tokens)
+
+	s.Md.Inline.Tokenize(s)
+
+	// escaped spaces are only escaped for this rule, so are written as plain spaces
+	for _, tok := range s.Tokens[first:] {
+		if text, ok := tok.(*markdown.Text); ok {
+			text.Content = strings.Replace(text.Content, "\\ ", " ", -1)
+		}
+	}
+
+	s.PushClosingToken(&ScriptClose{Sub: marker == '~'})
+
+	s.Pos = end + 1
+	s.PosMax = max
+
+	return true
+}
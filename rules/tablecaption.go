@@ -0,0 +1,127 @@
+package rules
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type TableCaption struct {
+	lvl    int
+	Label  string
+	Text   string
+	Number int  // table number, assigned once the document is parsed, 0 for captions in page headers and footers
+	Below  bool // caption follows its table rather than preceding it
+}
+
+type TableReference struct {
+	lvl   int
+	Label string
+}
+
+func (j *TableCaption) Tag() string {
+	return "caption"
+}
+
+func (j *TableCaption) Opening() bool {
+	return true
+}
+
+func (j *TableCaption) Closing() bool {
+	return true
+}
+
+func (j *TableCaption) Block() bool {
+	return true
+}
+
+func (j *TableCaption) Level() int {
+	return j.lvl
+}
+
+func (j *TableCaption) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func (j *TableReference) Tag() string {
+	return "ref"
+}
+
+func (j *TableReference) Opening() bool {
+	return true
+}
+
+func (j *TableReference) Closing() bool {
+	return true
+}
+
+func (j *TableReference) Block() bool {
+	return false
+}
+
+func (j *TableReference) Level() int {
+	return j.lvl
+}
+
+func (j *TableReference) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+func RuleTableCaption(s *markdown.StateBlock, startLine, endLine int, silent bool) (_ bool) {
+	shift := s.TShift[startLine]
+	if shift < 0 {
+		return
+	}
+
+	pos := s.BMarks[startLine] + shift
+	line := s.Src[pos:s.EMarks[startLine]]
+
+	if !strings.HasPrefix(line, "\\tcaption") {
+		return
+	}
+	line = line[len("\\tcaption"):]
+	if line != "" && line[0] != ' ' && line[0] != '{' {
+		return
+	}
+
+	tok := TableCaption{}
+	if strings.HasPrefix(line, "{") {
+		end := strings.IndexByte(line, '}')
+		if end < 0 {
+			return
+		}
+		tok.Label = strings.TrimSpace(line[1:end])
+		line = line[end+1:]
+	}
+	tok.Text = strings.TrimSpace(line)
+
+	if silent {
+		return true
+	}
+
+	s.Line = startLine + 1
+	s.PushToken(&tok)
+
+	return true
+}
+
+func RuleTableReference(s *markdown.StateInline, silent bool) (_ bool) {
+	src := s.Src
+	start := s.Pos
+	marker := "\\tref{"
+
+	if start >= s.PosMax || !strings.HasPrefix(src[start:s.PosMax], marker) {
+		return
+	}
+	end := strings.IndexByte(src[start:s.PosMax], '}')
+	if end < 0 {
+		return
+	}
+
+	if !silent {
+		s.PushToken(&TableReference{Label: strings.TrimSpace(src[start+len(marker) : start+end])})
+	}
+	s.Pos = start + end + 1
+
+	return true
+}
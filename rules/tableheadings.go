@@ -2,7 +2,6 @@ package rules
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 
 	"gitlab.com/golang-commonmark/markdown"
@@ -12,9 +11,13 @@ type TableHeader struct {
 	lvl       int
 	Lines     bool
 	Size      SizeMode
-	Cols      []float64
-	Colsum    float64
+	Width     Length    // table width when Size is SizeFixed
+	Cols      []float64 // column ratios, or absolute widths for columns with a unit
+	ColUnits  []string  // unit of each column width, empty for ratio columns
+	Colsum    float64   // sum of the ratio columns
 	Alignment AlignMode
+	Padding   []Length // cell padding, empty for the default, otherwise horizontal then optionally vertical padding
+	VAlign    VAlignMode
 }
 
 type SizeMode int
@@ -24,6 +27,7 @@ const (
 	SizeWrap                      // 1
 	SizeHalf                      // 2
 	SizeFull                      // 3
+	SizeFixed                     // 4
 )
 
 type AlignMode int
@@ -34,6 +38,14 @@ const (
 	AlignRight                   // 2
 )
 
+type VAlignMode int
+
+const (
+	VAlignTop    VAlignMode = iota // 0
+	VAlignMiddle                   // 1
+	VAlignBottom                   // 2
+)
+
 func (j *TableHeader) Tag() string {
 	return "thead"
 }
@@ -86,6 +98,7 @@ func RuleTableSettings(s *markdown.StateBlock, startLine, endLine int, silent bo
 		Size:      SizeWrap,
 		Cols:      []float64{},
 		Alignment: AlignCenter,
+		VAlign:    VAlignTop,
 	}
 
 	exprs := strings.Split(src[pos+6:s.BMarks[startLine+1]], " ")
@@ -111,9 +124,13 @@ func RuleTableSettings(s *markdown.StateBlock, startLine, endLine int, silent bo
 			case "sfull":
 				tok.Size = SizeFull
 			case "swrap":
-				fallthrough
+				tok.Size = SizeWrap
 			default:
 				tok.Size = SizeWrap
+				if l, err := ParseLength(exp[1:]); err == nil && l.Unit != "" {
+					tok.Size = SizeFixed
+					tok.Width = l
+				}
 			}
 		case strings.HasPrefix(exp, "c"):
 			exp = exp[1:]
@@ -123,15 +140,45 @@ func RuleTableSettings(s *markdown.StateBlock, startLine, endLine int, silent bo
 				if t == "" {
 					continue
 				}
-				f, err := strconv.ParseFloat(t, 64)
+				l, err := ParseLength(t)
 				if err != nil {
-					fmt.Printf("[docgen] Error parsing column size: %v", err)
+					fmt.Printf("[docgen] Error parsing column size: %v\r\n", err)
 					return
 				}
-				tok.Cols = append(tok.Cols, f)
-				sum += f
+				tok.Cols = append(tok.Cols, l.Value)
+				tok.ColUnits = append(tok.ColUnits, l.Unit)
+				if l.Unit == "" {
+					sum += l.Value
+				}
 			}
 			tok.Colsum = sum
+		case strings.HasPrefix(exp, "p"):
+			ts := strings.Split(exp[1:], ":")
+			if len(ts) > 2 {
+				fmt.Printf("[docgen] Error parsing cell padding: expected at most 2 values, got %v\r\n", len(ts))
+				return
+			}
+			tok.Padding = []Length{}
+			for _, t := range ts {
+				l, err := ParseLength(t)
+				if err == nil && l.Unit == "%" {
+					err = fmt.Errorf("invalid length %q: padding can't be a percentage", t)
+				}
+				if err != nil {
+					fmt.Printf("[docgen] Error parsing cell padding: %v\r\n", err)
+					return
+				}
+				tok.Padding = append(tok.Padding, l)
+			}
+		case strings.HasPrefix(exp, "v"):
+			switch exp {
+			case "vm":
+				tok.VAlign = VAlignMiddle
+			case "vb":
+				tok.VAlign = VAlignBottom
+			default:
+				tok.VAlign = VAlignTop
+			}
 		}
 	}
 
@@ -0,0 +1,117 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type TabStops struct {
+	lvl   int
+	Stops []TabStop // stops in the order given, empty to return to the default stops
+}
+
+// TabStop is a position text following a tab is aligned to
+type TabStop struct {
+	Position Length // from the left margin, a percentage is of the line width
+	Align    TabAlignMode
+	Leader   string // character repeated to fill the space before the stop, empty for blank space
+}
+
+type TabAlignMode int
+
+const (
+	TabLeft    TabAlignMode = iota // 0 text starts at the stop
+	TabRight                       // 1 text ends at the stop
+	TabDecimal                     // 2 the decimal point of a number is at the stop
+)
+
+func (j *TabStops) Tag() string {
+	return "tabs"
+}
+
+func (j *TabStops) Opening() bool {
+	return true
+}
+
+func (j *TabStops) Closing() bool {
+	return true
+}
+
+func (j *TabStops) Block() bool {
+	return true
+}
+
+func (j *TabStops) Level() int {
+	return j.lvl
+}
+
+func (j *TabStops) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+// RuleTabs parses \tabs followed by stops of the form [left|right|decimal:]position[:leader]
+func RuleTabs(s *markdown.StateBlock, startLine, endLine int, silent bool) (_ bool) {
+	shift := s.TShift[startLine]
+	if shift < 0 {
+		return
+	}
+
+	pos := s.BMarks[startLine] + shift
+	line := strings.TrimSpace(s.Src[pos:s.EMarks[startLine]])
+
+	if line != "\\tabs" && !strings.HasPrefix(line, "\\tabs ") {
+		return
+	}
+
+	tok := TabStops{}
+	for _, arg := range strings.Fields(line[len("\\tabs"):]) {
+		stop, err := parseTabStop(arg)
+		if err != nil {
+			fmt.Printf("[docgen] Error parsing tab stop: %v\r\n", err)
+			return
+		}
+		tok.Stops = append(tok.Stops, stop)
+	}
+
+	if silent {
+		return true
+	}
+
+	s.Line = startLine + 1
+	s.PushToken(&tok)
+
+	return true
+}
+
+func parseTabStop(arg string) (TabStop, error) {
+	stop := TabStop{}
+	parts := strings.Split(arg, ":")
+	switch strings.ToLower(parts[0]) {
+	case "left":
+		parts = parts[1:]
+	case "right":
+		stop.Align = TabRight
+		parts = parts[1:]
+	case "decimal":
+		stop.Align = TabDecimal
+		parts = parts[1:]
+	}
+	if len(parts) == 0 || len(parts) > 2 {
+		return stop, fmt.Errorf("invalid tab stop %q", arg)
+	}
+	var err error
+	stop.Position, err = ParseLength(parts[0])
+	if err != nil {
+		return stop, err
+	}
+	if len(parts) == 2 {
+		if utf8.RuneCountInString(parts[1]) != 1 {
+			return stop, fmt.Errorf("invalid tab stop %q: leader must be a single character", arg)
+		}
+		stop.Leader = parts[1]
+	}
+	return stop, nil
+}
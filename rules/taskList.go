@@ -0,0 +1,86 @@
+package rules
+
+import (
+	"strings"
+
+	"gitlab.com/golang-commonmark/markdown"
+)
+
+type TaskItemOpen struct {
+	lvl     int
+	Checked bool
+}
+
+func (j *TaskItemOpen) Tag() string {
+	return "li"
+}
+
+func (j *TaskItemOpen) Opening() bool {
+	return true
+}
+
+func (j *TaskItemOpen) Closing() bool {
+	return false
+}
+
+func (j *TaskItemOpen) Block() bool {
+	return true
+}
+
+func (j *TaskItemOpen) Level() int {
+	return j.lvl
+}
+
+func (j *TaskItemOpen) SetLevel(lvl int) {
+	j.lvl = lvl
+}
+
+// RuleTaskList turns list items starting with [ ] or [x] into task items, with a checkbox in place of their bullet
+// it runs once the inline content is parsed, replacing the item's opening token and removing the marker from its text
+func RuleTaskList(s *markdown.StateCore) {
+	tokens := s.Tokens
+	for i := 0; i+2 < len(tokens); i++ {
+		item, ok := tokens[i].(*markdown.ListItemOpen)
+		if !ok {
+			continue
+		}
+		if _, ok := tokens[i+1].(*markdown.ParagraphOpen); !ok {
+			continue
+		}
+		il, ok := tokens[i+2].(*markdown.Inline)
+		if !ok || len(il.Children) == 0 {
+			continue
+		}
+		text, ok := il.Children[0].(*markdown.Text)
+		if !ok {
+			continue
+		}
+		checked, length := taskMarker(text.Content)
+		if length == 0 || !strings.HasPrefix(il.Content, text.Content[:3]) {
+			// an escaped bracket isn't a task marker
+			continue
+		}
+		text.Content = text.Content[length:]
+		il.Content = strings.TrimLeft(il.Content[3:], " \t")
+		tokens[i] = &TaskItemOpen{lvl: item.Lvl, Checked: checked}
+	}
+}
+
+// taskMarker reads a task marker of the form [ ] or [x] at the start of str, giving the length of the marker and the spaces after it
+func taskMarker(str string) (checked bool, length int) {
+	if len(str) < 3 || str[0] != '[' || str[2] != ']' {
+		return
+	}
+	switch str[1] {
+	case ' ':
+	case 'x', 'X':
+		checked = true
+	default:
+		return
+	}
+	rest := str[3:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return false, 0
+	}
+	return checked, len(str) - len(strings.TrimLeft(rest, " \t"))
+}
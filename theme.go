@@ -0,0 +1,313 @@
+package docgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*Theme A style sheet for the markdown elements of a document, set with PdfConfig.Theme
+ * Fields:
+ *   Colors:      named colours, usable by name in place of a colour in the styles
+ *   Paragraph:   body text, which the other elements are written in unless their own style changes it
+ *   Headings:    heading levels 1 to 6, their font sizes default to the SizesConfig heading sizes
+ *   Blockquote:  text in blockquotes, with a bar drawn down their left side if a border colour is set, in the border colour and line width,
+ *                and the background shading them
+ *   Code:        code blocks and inline code, with the background shading code blocks
+ *   Syntax:      the styles of the kinds of token in fenced code blocks, coloured by the language in the info string of the fence,
+ *                by kind: "keyword", "type", "literal", "string", "number", "comment" and "key" (JSON member names),
+ *                with "lineNumber" styling the line numbers of numbered blocks, anything they leave empty is taken from Code
+ *   Link:        link text
+ *   Highlight:   text marked with ==, with the background shading the text
+ *   Table:       table cells, with the border colour and line width used for the table lines
+ *   TableHeader: table header cells, with the background shading the header cells
+ *   Hr:          horizontal rules, drawn in the border colour, line width and line style
+ *   Footnote:    footnotes and endnotes, with the rule separating them from the page content drawn in the border colour and line width,
+ *                and the space after each footnote, none by default, their font size defaults to the SizesConfig footnote size
+ *   Callout:     boxes around content, from the \box directive or blockquotes starting with a marker such as [!NOTE],
+ *                with the border drawn in the border colour and line width and the background shading the box
+ *   Callouts:    the styles of kinds of callout by name, such as "note" or "warning", which take anything they leave empty from Callout
+ * Fields left empty keep the default style, and the theme can be loaded from JSON with ParseTheme, or from YAML by unmarshalling into a Theme with a YAML library
+ */
+type Theme struct {
+	Colors      map[string]string `json:"colors" yaml:"colors"`
+	Paragraph   Style             `json:"paragraph" yaml:"paragraph"`
+	Headings    [6]Style          `json:"headings" yaml:"headings"`
+	Blockquote  Style             `json:"blockquote" yaml:"blockquote"`
+	Code        Style             `json:"code" yaml:"code"`
+	Syntax      map[string]Style  `json:"syntax" yaml:"syntax"`
+	Link        Style             `json:"link" yaml:"link"`
+	Highlight   Style             `json:"highlight" yaml:"highlight"`
+	Table       Style             `json:"table" yaml:"table"`
+	TableHeader Style             `json:"tableHeader" yaml:"tableHeader"`
+	Hr          Style             `json:"hr" yaml:"hr"`
+	Footnote    Style             `json:"footnote" yaml:"footnote"`
+	Callout     Style             `json:"callout" yaml:"callout"`
+	Callouts    map[string]Style  `json:"callouts" yaml:"callouts"`
+}
+
+/*Style The styling of a markdown element
+ * Fields:
+ *   FontFamily:  font to write the text in, one of the core pdf fonts "Arial", "Helvetica", "Times" or "Courier"
+ *   FontStyle:   any of "B" (bold), "I" (italic) and "U" (underline), added to the style of the surrounding text, or "regular" to clear it
+ *   FontSize:    font size in points
+ *   Color:       text colour
+ *   Background:  colour to shade the element with, for elements that have a background
+ *   BorderColor: colour of the lines drawn for the element
+ *   LineWidth:   width of the lines drawn for the element, in the document unit
+ *   LineStyle:   styles of the lines drawn for horizontal rules, any of "thick", "double", "dashed", "dotted" and "short" separated by spaces
+ *   SpaceBefore: space above paragraphs and headings, in the document unit, none by default
+ *   SpaceAfter:  space below paragraphs and headings, in the document unit, a line of body text by default
+ * For spacing, a negative value leaves no space rather than the default
+ * Colours are given as "#rrggbb" or "#rgb", a basic colour name such as "red" or "grey", or the name of a colour in Theme.Colors
+ */
+type Style struct {
+	FontFamily  string  `json:"fontFamily" yaml:"fontFamily"`
+	FontStyle   string  `json:"fontStyle" yaml:"fontStyle"`
+	FontSize    float64 `json:"fontSize" yaml:"fontSize"`
+	Color       string  `json:"color" yaml:"color"`
+	Background  string  `json:"background" yaml:"background"`
+	BorderColor string  `json:"borderColor" yaml:"borderColor"`
+	LineWidth   float64 `json:"lineWidth" yaml:"lineWidth"`
+	LineStyle   string  `json:"lineStyle" yaml:"lineStyle"`
+	SpaceBefore float64 `json:"spaceBefore" yaml:"spaceBefore"`
+	SpaceAfter  float64 `json:"spaceAfter" yaml:"spaceAfter"`
+}
+
+// ParseTheme reads a theme from JSON
+func ParseTheme(data []byte) (*Theme, error) {
+	theme := &Theme{}
+	if err := json.Unmarshal(data, theme); err != nil {
+		return nil, fmt.Errorf("invalid theme: %v", err)
+	}
+	return theme, nil
+}
+
+// defaultTheme is the styling used for anything a theme doesn't set
+var defaultTheme = Theme{
+	Paragraph: Style{FontFamily: "Arial", Color: "black"},
+	Code:      Style{FontFamily: "Courier", Background: "#b4b4b4"},
+	Syntax: map[string]Style{
+		"keyword":    {Color: "#00008b", FontStyle: "B"},
+		"type":       {Color: "#005f5f"},
+		"literal":    {Color: "#0000cd"},
+		"string":     {Color: "#8b0000"},
+		"number":     {Color: "#6a0dad"},
+		"comment":    {Color: "#006400", FontStyle: "I"},
+		"key":        {Color: "#00008b"},
+		"linenumber": {Color: "#505050"},
+	},
+	Link:        Style{FontStyle: "U"},
+	Highlight:   Style{Background: "yellow"},
+	TableHeader: Style{FontStyle: "B"},
+	Callout:     Style{BorderColor: "grey", Background: "#f2f2f2"},
+	Callouts: map[string]Style{
+		"note":      {BorderColor: "#0969da", Background: "#ddf4ff"},
+		"tip":       {BorderColor: "#1a7f37", Background: "#dafbe1"},
+		"important": {BorderColor: "#8250df", Background: "#fbefff"},
+		"warning":   {BorderColor: "#9a6700", Background: "#fff8c5"},
+		"caution":   {BorderColor: "#cf222e", Background: "#ffebe9"},
+	},
+}
+
+// merged gives the theme with any fields it leaves empty taken from base
+func (t *Theme) merged(base Theme) Theme {
+	if t == nil {
+		return base
+	}
+	m := Theme{Colors: map[string]string{}}
+	for name, color := range base.Colors {
+		m.Colors[name] = color
+	}
+	for name, color := range t.Colors {
+		m.Colors[name] = color
+	}
+	m.Paragraph = t.Paragraph.merged(base.Paragraph)
+	for i := range m.Headings {
+		m.Headings[i] = t.Headings[i].merged(base.Headings[i])
+	}
+	m.Blockquote = t.Blockquote.merged(base.Blockquote)
+	m.Code = t.Code.merged(base.Code)
+	m.Syntax = mergedStyles(t.Syntax, base.Syntax)
+	m.Link = t.Link.merged(base.Link)
+	m.Highlight = t.Highlight.merged(base.Highlight)
+	m.Table = t.Table.merged(base.Table)
+	m.TableHeader = t.TableHeader.merged(base.TableHeader)
+	m.Hr = t.Hr.merged(base.Hr)
+	m.Footnote = t.Footnote.merged(base.Footnote)
+	m.Callout = t.Callout.merged(base.Callout)
+	m.Callouts = mergedStyles(t.Callouts, base.Callouts)
+	return m
+}
+
+// mergedStyles gives the styles by name with any fields they leave empty taken from the base style of the same name,
+// and the base styles they don't name, with names in lower case
+func mergedStyles(styles, base map[string]Style) map[string]Style {
+	m := map[string]Style{}
+	for name, style := range base {
+		m[strings.ToLower(name)] = style
+	}
+	for name, style := range styles {
+		name = strings.ToLower(name)
+		m[name] = style.merged(m[name])
+	}
+	return m
+}
+
+// merged gives the style with any fields it leaves empty taken from base
+func (s Style) merged(base Style) Style {
+	if s.FontFamily == "" {
+		s.FontFamily = base.FontFamily
+	}
+	if s.FontStyle == "" {
+		s.FontStyle = base.FontStyle
+	}
+	if s.FontSize == 0 {
+		s.FontSize = base.FontSize
+	}
+	if s.Color == "" {
+		s.Color = base.Color
+	}
+	if s.Background == "" {
+		s.Background = base.Background
+	}
+	if s.BorderColor == "" {
+		s.BorderColor = base.BorderColor
+	}
+	if s.LineWidth == 0 {
+		s.LineWidth = base.LineWidth
+	}
+	if s.LineStyle == "" {
+		s.LineStyle = base.LineStyle
+	}
+	if s.SpaceBefore == 0 {
+		s.SpaceBefore = base.SpaceBefore
+	}
+	if s.SpaceAfter == 0 {
+		s.SpaceAfter = base.SpaceAfter
+	}
+	return s
+}
+
+// rgb is a colour as red, green and blue components from 0 to 255
+type rgb struct {
+	r, g, b int
+}
+
+// basicColors are the colour names understood without being defined in the theme
+var basicColors = map[string]rgb{
+	"black":  {0, 0, 0},
+	"white":  {255, 255, 255},
+	"grey":   {128, 128, 128},
+	"gray":   {128, 128, 128},
+	"silver": {192, 192, 192},
+	"red":    {255, 0, 0},
+	"maroon": {128, 0, 0},
+	"orange": {255, 165, 0},
+	"yellow": {255, 255, 0},
+	"green":  {0, 128, 0},
+	"lime":   {0, 255, 0},
+	"teal":   {0, 128, 128},
+	"blue":   {0, 0, 255},
+	"navy":   {0, 0, 128},
+	"purple": {128, 0, 128},
+}
+
+// parseColor reads a colour given as #rrggbb, #rgb, or a name from colors or the basic colours
+func parseColor(str string, colors map[string]string) (rgb, error) {
+	name := strings.ToLower(strings.TrimSpace(str))
+	for i := 0; i < 10 && colors[name] != ""; i++ {
+		// theme colours can be defined in terms of other colours
+		name = strings.ToLower(strings.TrimSpace(colors[name]))
+	}
+	if c, ok := basicColors[name]; ok {
+		return c, nil
+	}
+	if !strings.HasPrefix(name, "#") {
+		return rgb{}, fmt.Errorf("unknown colour %q", str)
+	}
+	hex := name[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return rgb{}, fmt.Errorf("invalid colour %q", str)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return rgb{}, fmt.Errorf("invalid colour %q", str)
+	}
+	return rgb{int(v >> 16), int(v >> 8 & 0xff), int(v & 0xff)}, nil
+}
+
+// color looks up a colour, which can be named in the theme, reporting if it is set and valid
+func (d *Document) color(str string) (rgb, bool) {
+	if str == "" {
+		return rgb{}, false
+	}
+	c, err := parseColor(str, d.theme.Colors)
+	if err != nil {
+		if d.measuring {
+			return rgb{}, false
+		}
+		fmt.Printf("[docgen] Error parsing colour: %v\r\n", err)
+		return rgb{}, false
+	}
+	return c, true
+}
+
+// textStyle is the font and colour text is currently written in, saved while an element's style is applied
+type textStyle struct {
+	family     string
+	style      string
+	size       float64
+	color      rgb
+	highlight  *rgb
+	rise       float64
+	lineHeight float64
+}
+
+// pushStyle applies the style of an element to the text that follows, until popStyle returns to the style before it
+func (d *Document) pushStyle(s Style) {
+	d.styles = append(d.styles, textStyle{d.fontFamily, d.fontStyle, d.fontSize, d.textColor, d.highlight, d.rise, d.lineHeight})
+	d.setStyle(s)
+}
+
+// popStyle returns to the style in use before the last pushStyle
+func (d *Document) popStyle() {
+	l := len(d.styles)
+	if l == 0 {
+		return
+	}
+	s := d.styles[l-1]
+	d.styles = d.styles[:l-1]
+	d.fontFamily, d.fontStyle, d.fontSize, d.textColor, d.highlight, d.rise, d.lineHeight = s.family, s.style, s.size, s.color, s.highlight, s.rise, s.lineHeight
+	d.flushTextStyling()
+}
+
+// setStyle changes the font and colour text is written in to those set by the style
+func (d *Document) setStyle(s Style) {
+	if s.FontFamily != "" {
+		d.fontFamily = s.FontFamily
+	}
+	switch s.FontStyle {
+	case "":
+	case "regular":
+		d.fontStyle = ""
+	default:
+		for _, style := range strings.ToUpper(s.FontStyle) {
+			if !strings.ContainsRune(d.fontStyle, style) {
+				d.fontStyle += string(style)
+			}
+		}
+	}
+	if s.FontSize != 0 {
+		d.fontSize = s.FontSize
+		d.lineHeight = d.fontSize * d.sizes.LineSpacing / d.fpdf.GetConversionRatio()
+	}
+	if c, ok := d.color(s.Color); ok {
+		d.textColor = c
+	}
+	d.flushTextStyling()
+}